@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AudioChain 声明式的参考音频预处理链，写法如 "highpass=80,denoise,trim_silence,max=30,loudnorm"。
+// 无论书写顺序如何，均按 高通 → 降噪 → 去首尾静音 → 截断 → 响度归一 的固定顺序执行，保证同一配置结果一致
+type AudioChain struct {
+	HighPassHz  int     // 高通截止频率，滤掉空调、电流等低频噪声
+	DenoiseNR   int     // afftdn 降噪强度（dB）
+	TrimSilence bool    // 去掉首尾静音
+	MaxSeconds  float64 // 截断到最大参考时长
+	Loudnorm    bool    // EBU R128 响度归一
+}
+
+const (
+	defaultHighPassHz = 80
+	defaultDenoiseNR  = 12
+	// 首尾静音判定阈值；中间停顿保留，避免改变说话节奏
+	silenceTrimFilter = "silenceremove=start_periods=1:start_silence=0.1:start_threshold=-50dB"
+	// EBU R128 响度归一参数
+	loudnormFilter = "loudnorm=I=-16:TP=-1.5:LRA=11"
+)
+
+// parseAudioChain 解析逗号分隔的处理步骤；空串或 none 表示只做格式转换
+func parseAudioChain(raw string) (AudioChain, error) {
+	var a AudioChain
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(strings.ToLower(item))
+		if item == "" || item == "none" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(item, "=")
+		switch key {
+		case "highpass":
+			a.HighPassHz = defaultHighPassHz
+			if hasVal {
+				hz, err := strconv.Atoi(val)
+				if err != nil || hz < 20 || hz > 1000 {
+					return a, fmt.Errorf("highpass 取值 20-1000 Hz: %q", val)
+				}
+				a.HighPassHz = hz
+			}
+		case "denoise":
+			a.DenoiseNR = defaultDenoiseNR
+			if hasVal {
+				nr, err := strconv.Atoi(val)
+				if err != nil || nr < 1 || nr > 97 {
+					return a, fmt.Errorf("denoise 取值 1-97 dB: %q", val)
+				}
+				a.DenoiseNR = nr
+			}
+		case "trim_silence":
+			a.TrimSilence = true
+		case "max":
+			sec, err := strconv.ParseFloat(val, 64)
+			if !hasVal || err != nil || sec <= 0 {
+				return a, fmt.Errorf("max 需为正数秒: %q", val)
+			}
+			a.MaxSeconds = sec
+		case "loudnorm":
+			a.Loudnorm = true
+		default:
+			return a, fmt.Errorf("未知的音频处理步骤: %q（可选 highpass、denoise、trim_silence、max、loudnorm）", item)
+		}
+	}
+	return a, nil
+}
+
+// String 规范写法，用于记录与比较；空链记为 none
+func (a AudioChain) String() string {
+	var parts []string
+	if a.HighPassHz > 0 {
+		parts = append(parts, fmt.Sprintf("highpass=%d", a.HighPassHz))
+	}
+	if a.DenoiseNR > 0 {
+		parts = append(parts, fmt.Sprintf("denoise=%d", a.DenoiseNR))
+	}
+	if a.TrimSilence {
+		parts = append(parts, "trim_silence")
+	}
+	if a.MaxSeconds > 0 {
+		parts = append(parts, "max="+strconv.FormatFloat(a.MaxSeconds, 'f', -1, 64))
+	}
+	if a.Loudnorm {
+		parts = append(parts, "loudnorm")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+// filter 拼接 ffmpeg -af 参数，空链返回空串
+func (a AudioChain) filter() string {
+	var filters []string
+	if a.HighPassHz > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%d", a.HighPassHz))
+	}
+	if a.DenoiseNR > 0 {
+		filters = append(filters, fmt.Sprintf("afftdn=nr=%d", a.DenoiseNR))
+	}
+	if a.TrimSilence {
+		// silenceremove 只处理开头，反转后再处理一次即可去掉结尾静音
+		filters = append(filters, silenceTrimFilter, "areverse", silenceTrimFilter, "areverse")
+	}
+	if a.MaxSeconds > 0 {
+		filters = append(filters, "atrim=0:"+strconv.FormatFloat(a.MaxSeconds, 'f', -1, 64))
+	}
+	if a.Loudnorm {
+		filters = append(filters, loudnormFilter)
+	}
+	return strings.Join(filters, ",")
+}
+
+// convertReferenceAudio 应用处理链并转换为 16kHz 单声道 PCM WAV
+func convertReferenceAudio(ctx context.Context, src, dst string, chain AudioChain) error {
+	args := []string{"-y", "-i", src}
+	if f := chain.filter(); f != "" {
+		args = append(args, "-af", f)
+	}
+	args = append(args, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", dst)
+	if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("%v | %s", err, stderr)
+	}
+	return nil
+}
+
+// audioChainFromForm 以 def 为基础读取表单：audio_filters 整体替换，trim_silence=true 追加去首尾静音；返回规范写法
+func audioChainFromForm(c *gin.Context, def string) (string, error) {
+	raw, ok := c.GetPostForm("audio_filters")
+	if !ok {
+		raw = def
+	}
+	chain, err := parseAudioChain(raw)
+	if err != nil {
+		return "", err
+	}
+	if parseBool(c.PostForm("trim_silence")) {
+		chain.TrimSilence = true
+	}
+	return chain.String(), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestParseAudioChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		canonical string
+		filter    string
+		wantErr   bool
+	}{
+		{name: "空串", raw: "", canonical: "none", filter: ""},
+		{name: "none", raw: "none", canonical: "none", filter: ""},
+		{name: "默认参数", raw: "highpass,denoise", canonical: "highpass=80,denoise=12",
+			filter: "highpass=f=80,afftdn=nr=12"},
+		{name: "乱序按固定顺序执行", raw: "loudnorm,max=30,trim_silence,denoise=20,highpass=100",
+			canonical: "highpass=100,denoise=20,trim_silence,max=30,loudnorm",
+			filter:    "highpass=f=100,afftdn=nr=20," + silenceTrimFilter + ",areverse," + silenceTrimFilter + ",areverse,atrim=0:30," + loudnormFilter},
+		{name: "大小写与空白", raw: " LoudNorm , MAX=12.5 ", canonical: "max=12.5,loudnorm",
+			filter: "atrim=0:12.5," + loudnormFilter},
+		{name: "重复步骤以最后一次为准", raw: "highpass=100,highpass=200", canonical: "highpass=200",
+			filter: "highpass=f=200"},
+		{name: "忽略空项", raw: "denoise,,none", canonical: "denoise=12", filter: "afftdn=nr=12"},
+		{name: "highpass 过低", raw: "highpass=10", wantErr: true},
+		{name: "highpass 过高", raw: "highpass=1001", wantErr: true},
+		{name: "denoise 非数字", raw: "denoise=x", wantErr: true},
+		{name: "denoise 越界", raw: "denoise=98", wantErr: true},
+		{name: "max 缺少取值", raw: "max", wantErr: true},
+		{name: "max 非正数", raw: "max=0", wantErr: true},
+		{name: "未知步骤", raw: "reverb", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := parseAudioChain(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAudioChain(%q) 期望出错，得到 %s", tt.raw, chain)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAudioChain(%q) 意外错误: %v", tt.raw, err)
+			}
+			if got := chain.String(); got != tt.canonical {
+				t.Errorf("String() = %q，期望 %q", got, tt.canonical)
+			}
+			if got := chain.filter(); got != tt.filter {
+				t.Errorf("filter() = %q，期望 %q", got, tt.filter)
+			}
+		})
+	}
+}
+
+// 规范写法再次解析应得到相同的处理链，任务与模版据此比较处理链是否一致
+func TestAudioChainCanonicalRoundTrip(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"trim_silence",
+		"loudnorm,highpass",
+		"max=7.25,denoise=3,highpass=20,trim_silence,loudnorm",
+	} {
+		chain, err := parseAudioChain(raw)
+		if err != nil {
+			t.Fatalf("parseAudioChain(%q): %v", raw, err)
+		}
+		again, err := parseAudioChain(chain.String())
+		if err != nil {
+			t.Fatalf("parseAudioChain(%q): %v", chain.String(), err)
+		}
+		if again != chain {
+			t.Errorf("%q 规范化后再解析得到 %+v，期望 %+v", raw, again, chain)
+		}
+	}
+}
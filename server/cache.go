@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 媒体缓存：按输入内容哈希 + 处理参数寻址，缓存规范化 WAV、静音 MP4 与 TTS 输出
+const (
+	cacheKindNormAudio   = "norm_audio"
+	cacheKindSilentVideo = "silent_video"
+	cacheKindTTS         = "tts"
+)
+
+var (
+	// 文件摘要按 路径+大小+修改时间 记忆，避免同一模板每次重新计算哈希
+	digestMemo sync.Map
+	// 淘汰与写入互斥，避免淘汰刚写入的条目
+	mediaCacheMu sync.Mutex
+	// 上次触发淘汰的时间（UnixNano），淘汰需遍历整个缓存目录，按 mediaCacheEvictInterval 节流
+	mediaCacheLastEvict atomic.Int64
+)
+
+const mediaCacheEvictInterval = time.Minute
+
+// fileDigest 返回文件内容的 sha256
+func fileDigest(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	memoKey := fmt.Sprintf("%s|%d|%d", path, st.Size(), st.ModTime().UnixNano())
+	if v, ok := digestMemo.Load(memoKey); ok {
+		return v.(string), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	digestMemo.Store(memoKey, sum)
+	return sum, nil
+}
+
+// mediaCacheKey 由输入摘要与处理参数组成缓存键
+func mediaCacheKey(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+func mediaCachePath(kind, key, ext string) string {
+	return filepath.Join(cfg.MediaCacheDir, kind, key[:2], key+ext)
+}
+
+// fetchCached 命中时把缓存文件拷贝到 dst 并刷新访问时间（用于 LRU）
+func fetchCached(kind, key, ext, dst string) bool {
+	if cfg.MediaCacheMaxBytes <= 0 {
+		return false
+	}
+	src := mediaCachePath(kind, key, ext)
+	if _, err := os.Stat(src); err != nil {
+		metricMediaCache.WithLabelValues(kind, "miss").Inc()
+		return false
+	}
+	if err := copyFile(src, dst); err != nil {
+		slog.Warn("读取媒体缓存失败", "kind", kind, "err", err)
+		metricMediaCache.WithLabelValues(kind, "miss").Inc()
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	metricMediaCache.WithLabelValues(kind, "hit").Inc()
+	return true
+}
+
+// storeCached 把处理结果写入缓存（先写唯一的临时文件再改名，多个进程共享缓存目录时互不干扰），随后按容量淘汰
+func storeCached(kind, key, ext, src string) {
+	if cfg.MediaCacheMaxBytes <= 0 {
+		return
+	}
+	dst := mediaCachePath(kind, key, ext)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		slog.Warn("创建媒体缓存目录失败", "err", err)
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		slog.Warn("写入媒体缓存失败", "kind", kind, "err", err)
+		return
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		slog.Warn("写入媒体缓存失败", "kind", kind, "err", err)
+		return
+	}
+	mediaCacheMu.Lock()
+	err = os.Rename(tmp, dst)
+	mediaCacheMu.Unlock()
+	if err != nil {
+		os.Remove(tmp)
+		slog.Warn("写入媒体缓存失败", "kind", kind, "err", err)
+		return
+	}
+	now := time.Now().UnixNano()
+	if last := mediaCacheLastEvict.Load(); now-last >= int64(mediaCacheEvictInterval) && mediaCacheLastEvict.CompareAndSwap(last, now) {
+		go evictMediaCache()
+	}
+}
+
+// evictMediaCache 总大小超过上限时按最近访问时间从旧到新删除
+func evictMediaCache() {
+	mediaCacheMu.Lock()
+	defer mediaCacheMu.Unlock()
+	type entry struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var entries []entry
+	var total int64
+	filepath.WalkDir(cfg.MediaCacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			// 写入中途退出的进程留下的临时文件
+			if time.Since(info.ModTime()) > time.Hour {
+				os.Remove(path)
+			}
+			return nil
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	metricMediaCacheBytes.Set(float64(total))
+	if total <= cfg.MediaCacheMaxBytes {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mtime.Before(entries[j].mtime) })
+	removed := 0
+	for _, e := range entries {
+		if total <= cfg.MediaCacheMaxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err == nil {
+			total -= e.size
+			removed++
+		}
+	}
+	metricMediaCacheBytes.Set(float64(total))
+	slog.Info("媒体缓存淘汰", "removed", removed, "size_bytes", total)
+}
+
+// cachedTransform 以 input 内容与参数为键：命中则直接产出 dst，否则执行 produce 并写入缓存
+func cachedTransform(kind, ext, input, dst string, params []string, produce func() error) (bool, error) {
+	digest, err := fileDigest(input)
+	if err != nil {
+		// 无法计算摘要时退化为直接处理
+		return false, produce()
+	}
+	key := mediaCacheKey(append([]string{kind, digest}, params...)...)
+	if fetchCached(kind, key, ext, dst) {
+		return true, nil
+	}
+	if err := produce(); err != nil {
+		return false, err
+	}
+	storeCached(kind, key, ext, dst)
+	return false, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMediaCacheKey(t *testing.T) {
+	base := mediaCacheKey(cacheKindTTS, "digest", "params")
+	if len(base) != 64 {
+		t.Fatalf("缓存键应为 sha256 十六进制: %q", base)
+	}
+	if mediaCacheKey(cacheKindTTS, "digest", "params") != base {
+		t.Error("相同输入应得到相同的键")
+	}
+	for _, parts := range [][]string{
+		{cacheKindNormAudio, "digest", "params"},
+		{cacheKindTTS, "digest2", "params"},
+		{cacheKindTTS, "digest", "params2"},
+		// 分隔符避免拼接歧义
+		{cacheKindTTS, "digestp", "arams"},
+	} {
+		if mediaCacheKey(parts...) == base {
+			t.Errorf("mediaCacheKey(%q) 与基准键冲突", parts)
+		}
+	}
+}
+
+// TTS 缓存键取自实际发送的合成参数：改动任一参数都会换键，参考音频只经摘要参与
+func TestTTSCacheKeyParams(t *testing.T) {
+	key := func(req AutoProcessReq) string {
+		params, err := json.Marshal(ttsInvokeParams(&req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mediaCacheKey(cacheKindTTS, "digest", string(params))
+	}
+	base := key(AutoProcessReq{Speaker: "s1", Text: "你好"})
+	tests := []struct {
+		name string
+		req  AutoProcessReq
+		same bool
+	}{
+		{name: "相同参数", req: AutoProcessReq{Speaker: "s1", Text: "你好"}, same: true},
+		{name: "与合成无关的字段", req: AutoProcessReq{Speaker: "s1", Text: "你好", TaskName: "其他任务", UseTTS: true}, same: true},
+		{name: "不同文本", req: AutoProcessReq{Speaker: "s1", Text: "您好"}},
+		{name: "不同音色", req: AutoProcessReq{Speaker: "s2", Text: "你好"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key(tt.req) == base; got != tt.same {
+				t.Errorf("键相同=%v，期望 %v", got, tt.same)
+			}
+		})
+	}
+	if _, ok := ttsInvokeParams(&AutoProcessReq{})["reference_audio"]; ok {
+		t.Error("合成参数不应包含参考音频路径")
+	}
+}
+
+func TestMediaCacheStoreFetch(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	dir := t.TempDir()
+	cfg.MediaCacheDir = filepath.Join(dir, "cache")
+	cfg.MediaCacheMaxBytes = 1 << 20
+	// 节流期内，storeCached 不会在后台触发淘汰
+	mediaCacheLastEvict.Store(time.Now().UnixNano())
+
+	src := filepath.Join(dir, "src.wav")
+	if err := os.WriteFile(src, []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key := mediaCacheKey(cacheKindTTS, "roundtrip")
+	dst := filepath.Join(dir, "dst.wav")
+	if fetchCached(cacheKindTTS, key, ".wav", dst) {
+		t.Fatal("写入前不应命中")
+	}
+	storeCached(cacheKindTTS, key, ".wav", src)
+	if !fetchCached(cacheKindTTS, key, ".wav", dst) {
+		t.Fatal("写入后应命中")
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "audio" {
+		t.Errorf("取回内容 %q", data)
+	}
+	if fetchCached(cacheKindTTS, key, ".timeline.json", dst) {
+		t.Error("不同扩展名是独立条目")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(cfg.MediaCacheDir, cacheKindTTS, key[:2], "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Errorf("写入后残留临时文件: %v", leftovers)
+	}
+
+	cfg.MediaCacheMaxBytes = 0
+	if fetchCached(cacheKindTTS, key, ".wav", dst) {
+		t.Error("缓存关闭时不应命中")
+	}
+}
+
+func TestEvictMediaCache(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.MediaCacheDir = t.TempDir()
+	cfg.MediaCacheMaxBytes = 10
+
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+		keep bool
+	}{
+		{name: "tts/aa/oldest.wav", size: 6, age: 3 * time.Hour},
+		{name: "tts/bb/older.wav", size: 6, age: 2 * time.Hour},
+		{name: "tts/cc/newest.wav", size: 6, age: time.Minute, keep: true},
+		// 写入中途退出留下的临时文件：过期的清理，新的可能仍在写入
+		{name: "tts/dd/x.wav.1.tmp", size: 1, age: 2 * time.Hour},
+		{name: "tts/dd/y.wav.2.tmp", size: 1, age: time.Minute, keep: true},
+	}
+	for _, f := range files {
+		path := filepath.Join(cfg.MediaCacheDir, f.name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, f.size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-f.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evictMediaCache()
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(cfg.MediaCacheDir, f.name))
+		if kept := err == nil; kept != f.keep {
+			t.Errorf("%s 保留=%v，期望 %v", f.name, kept, f.keep)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ComposeOptions 成片合成选项：背景音乐、片头片尾、台标与输出编码。素材引用 music|bumper|logo 素材库，
+// 提交任务时固定版本
+type ComposeOptions struct {
+	Music        string  `json:"music,omitempty"`
+	MusicVersion int     `json:"music_version,omitempty"`
+	MusicAttenDB float64 `json:"music_atten_db"` // 背景音乐整体衰减（dB）
+	MusicDuckDB  float64 `json:"music_duck_db"`  // 人声期间在整体衰减基础上再压低（dB），0 为不闪避
+	Intro        string  `json:"intro,omitempty"`
+	IntroVersion int     `json:"intro_version,omitempty"`
+	Outro        string  `json:"outro,omitempty"`
+	OutroVersion int     `json:"outro_version,omitempty"`
+	Logo         string  `json:"logo,omitempty"`
+	LogoVersion  int     `json:"logo_version,omitempty"`
+	LogoPosition string  `json:"logo_position,omitempty"` // top-left | top-right | bottom-left | bottom-right
+	LogoOpacity  float64 `json:"logo_opacity,omitempty"`  // 0-1
+	LogoScale    float64 `json:"logo_scale,omitempty"`    // 台标宽度占画面宽度的比例
+	CRF          int     `json:"crf"`
+	Preset       string  `json:"preset,omitempty"`
+	AudioBitrate string  `json:"audio_bitrate,omitempty"`
+}
+
+const (
+	logoTopLeft     = "top-left"
+	logoTopRight    = "top-right"
+	logoBottomLeft  = "bottom-left"
+	logoBottomRight = "bottom-right"
+)
+
+var (
+	x264Presets    = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+	bitratePattern = regexp.MustCompile(`^[0-9]{2,3}k$`)
+)
+
+// assets 返回引用的某类素材标识
+func (o *ComposeOptions) assets(kind string) []string {
+	var names []string
+	switch kind {
+	case templateKindMusic:
+		names = []string{o.Music}
+	case templateKindBumper:
+		names = []string{o.Intro, o.Outro}
+	case templateKindLogo:
+		names = []string{o.Logo}
+	}
+	return slices.DeleteFunc(names, func(n string) bool { return n == "" })
+}
+
+// validate 检查取值范围；配置默认值与表单共用
+func (o *ComposeOptions) validate() error {
+	switch {
+	case o.MusicAttenDB < 0 || o.MusicAttenDB > 60:
+		return fmt.Errorf("背景音乐衰减取值 0-60 dB: %g", o.MusicAttenDB)
+	case o.MusicDuckDB < 0 || o.MusicDuckDB > 40:
+		return fmt.Errorf("背景音乐闪避取值 0-40 dB: %g", o.MusicDuckDB)
+	case o.LogoOpacity <= 0 || o.LogoOpacity > 1:
+		return fmt.Errorf("台标不透明度取值 (0, 1]: %g", o.LogoOpacity)
+	case o.LogoScale < 0.02 || o.LogoScale > 0.5:
+		return fmt.Errorf("台标宽度比例取值 0.02-0.5: %g", o.LogoScale)
+	case o.CRF < 0 || o.CRF > 51:
+		return fmt.Errorf("crf 取值 0-51: %d", o.CRF)
+	case !slices.Contains(x264Presets, o.Preset):
+		return fmt.Errorf("preset 仅支持 %s: %q", strings.Join(x264Presets, "|"), o.Preset)
+	case !bitratePattern.MatchString(o.AudioBitrate):
+		return fmt.Errorf("音频码率格式如 192k: %q", o.AudioBitrate)
+	}
+	switch o.LogoPosition {
+	case logoTopLeft, logoTopRight, logoBottomLeft, logoBottomRight:
+	default:
+		return fmt.Errorf("台标位置仅支持 top-left|top-right|bottom-left|bottom-right: %q", o.LogoPosition)
+	}
+	return nil
+}
+
+// composeOptionsFromForm 以 COMPOSE_* 默认值为基础读取表单：bgm、intro、outro、logo（none 表示不使用，
+// 可用 <字段>_version 固定版本）、bgm_attenuation、bgm_duck、logo_position、logo_opacity、logo_scale、
+// encode_crf、encode_preset、audio_bitrate；compose=false 关闭合成。未使用任何素材时返回 nil
+func composeOptionsFromForm(c *gin.Context, user string) (*ComposeOptions, error) {
+	if v := strings.ToLower(strings.TrimSpace(c.PostForm("compose"))); v == "false" || v == "0" || v == "none" {
+		return nil, nil
+	}
+	o := cfg.ComposeDefaults
+	var err error
+	parseFloat := func(field string, dst *float64) {
+		if v := strings.TrimSpace(c.PostForm(field)); v != "" && err == nil {
+			f, perr := strconv.ParseFloat(v, 64)
+			if perr != nil {
+				err = fmt.Errorf("%s 须为数字: %q", field, v)
+			}
+			*dst = f
+		}
+	}
+	parseFloat("bgm_attenuation", &o.MusicAttenDB)
+	parseFloat("bgm_duck", &o.MusicDuckDB)
+	parseFloat("logo_opacity", &o.LogoOpacity)
+	parseFloat("logo_scale", &o.LogoScale)
+	if err != nil {
+		return nil, err
+	}
+	if v := strings.TrimSpace(c.PostForm("encode_crf")); v != "" {
+		if o.CRF, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("encode_crf 须为整数: %q", v)
+		}
+	}
+	if v := strings.TrimSpace(c.PostForm("encode_preset")); v != "" {
+		o.Preset = v
+	}
+	if v := strings.TrimSpace(c.PostForm("audio_bitrate")); v != "" {
+		o.AudioBitrate = v
+	}
+	if v := strings.TrimSpace(c.PostForm("logo_position")); v != "" {
+		o.LogoPosition = v
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	for _, ref := range []struct {
+		field, kind string
+		name        *string
+		version     *int
+	}{
+		{"bgm", templateKindMusic, &o.Music, &o.MusicVersion},
+		{"intro", templateKindBumper, &o.Intro, &o.IntroVersion},
+		{"outro", templateKindBumper, &o.Outro, &o.OutroVersion},
+		{"logo", templateKindLogo, &o.Logo, &o.LogoVersion},
+	} {
+		if v, ok := c.GetPostForm(ref.field); ok {
+			*ref.name = strings.TrimSpace(v)
+		}
+		if *ref.name == "none" {
+			*ref.name = ""
+		}
+		if *ref.name == "" {
+			continue
+		}
+		item, _, err := findTemplateItem(ref.kind, *ref.name)
+		if err == nil && !templateVisible(item, user) {
+			err = fmt.Errorf("素材 %s 未找到", *ref.name)
+		}
+		if v := strings.TrimSpace(c.PostForm(ref.field + "_version")); err == nil && v != "" {
+			var version int
+			if version, err = strconv.Atoi(v); err == nil {
+				item, _, err = pinTemplateVersion(ref.kind, item, version)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 无效: %v", ref.field, err)
+		}
+		*ref.version = item.Version
+	}
+	if o.Music == "" && o.Intro == "" && o.Outro == "" && o.Logo == "" {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+// composeAsset 合成时使用的素材文件
+type composeAsset struct {
+	Path     string
+	Duration float64
+	HasAudio bool
+}
+
+// composePlan 由任务固定的素材版本解析出的文件；片头时长用于平移字幕
+type composePlan struct {
+	Opts                      ComposeOptions
+	Music, Intro, Outro, Logo *composeAsset
+}
+
+// planComposition 定位素材文件并探测片头片尾；素材在任务提交后被删除时失败
+func planComposition(ctx context.Context, o ComposeOptions) (*composePlan, error) {
+	plan := &composePlan{Opts: o}
+	locate := func(kind, name string, version int, probe bool) (*composeAsset, error) {
+		if name == "" {
+			return nil, nil
+		}
+		a := &composeAsset{Path: templateMediaPath(kind, name, version, "")}
+		if _, err := os.Stat(a.Path); err != nil {
+			return nil, fmt.Errorf("素材 %s 版本 %d 文件缺失", name, version)
+		}
+		if probe {
+			p, err := probeMedia(ctx, a.Path)
+			if err != nil {
+				return nil, err
+			}
+			a.Duration = p.duration()
+			a.HasAudio = p.stream("audio") != nil
+		}
+		return a, nil
+	}
+	var err error
+	if plan.Music, err = locate(templateKindMusic, o.Music, o.MusicVersion, false); err != nil {
+		return nil, err
+	}
+	if plan.Intro, err = locate(templateKindBumper, o.Intro, o.IntroVersion, true); err != nil {
+		return nil, err
+	}
+	if plan.Outro, err = locate(templateKindBumper, o.Outro, o.OutroVersion, true); err != nil {
+		return nil, err
+	}
+	if plan.Logo, err = locate(templateKindLogo, o.Logo, o.LogoVersion, false); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// introSeconds 片头时长，成片中正片的起点
+func (p *composePlan) introSeconds() float64 {
+	if p == nil || p.Intro == nil {
+		return 0
+	}
+	return p.Intro.Duration
+}
+
+// speechIntervals 由静音区间求出有声区间；短于 minGap 的停顿并入前后语句，避免音乐频繁起落
+func speechIntervals(silences []silenceSpan, duration, minGap float64) [][2]float64 {
+	var raw [][2]float64
+	cursor := 0.0
+	for _, s := range silences {
+		end := s.End
+		if end < 0 {
+			end = duration
+		}
+		if s.Start > cursor {
+			raw = append(raw, [2]float64{cursor, s.Start})
+		}
+		cursor = math.Max(cursor, end)
+	}
+	if cursor < duration {
+		raw = append(raw, [2]float64{cursor, duration})
+	}
+	var out [][2]float64
+	for _, r := range raw {
+		if n := len(out); n > 0 && r[0]-out[n-1][1] < minGap {
+			out[n-1][1] = r[1]
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// duckVolumeExpr 背景音乐音量表达式：人声区间内从 base 压低到 ducked，前后各 ramp 秒线性过渡
+func duckVolumeExpr(attenDB, duckDB float64, speech [][2]float64, ramp float64) string {
+	base := dbToGain(-attenDB)
+	if duckDB <= 0 || len(speech) == 0 {
+		return fmt.Sprintf("%.4f", base)
+	}
+	ducked := dbToGain(-attenDB - duckDB)
+	terms := make([]string, len(speech))
+	for i, s := range speech {
+		terms[i] = fmt.Sprintf("clip(min(t-%.3f,%.3f-t)/%.2f+1,0,1)", s[0], s[1], ramp)
+	}
+	return fmt.Sprintf("%.4f-%.4f*min(1,%s)", base, base-ducked, strings.Join(terms, "+"))
+}
+
+// logoOverlayPos overlay 滤镜的台标坐标，margin 为距画面边缘的像素
+func logoOverlayPos(position string, margin int) string {
+	switch position {
+	case logoTopLeft:
+		return fmt.Sprintf("x=%d:y=%d", margin, margin)
+	case logoBottomLeft:
+		return fmt.Sprintf("x=%d:y=main_h-overlay_h-%d", margin, margin)
+	case logoBottomRight:
+		return fmt.Sprintf("x=main_w-overlay_w-%d:y=main_h-overlay_h-%d", margin, margin)
+	default:
+		return fmt.Sprintf("x=main_w-overlay_w-%d:y=%d", margin, margin)
+	}
+}
+
+// composeArgs 拼接合成命令：正片叠加台标、混入闪避的背景音乐，再与片头片尾按正片规格拼接
+func composeArgs(plan *composePlan, src, dst string, main *mediaProbe, speech [][2]float64) ([]string, error) {
+	o := plan.Opts
+	v := main.stream("video")
+	if v == nil || main.stream("audio") == nil {
+		return nil, fmt.Errorf("成片缺少画面或音轨")
+	}
+	w, h := v.Width, v.Height
+	fps := parseFrameRate(v.AvgFrameRate)
+	if fps <= 0 {
+		fps = 25
+	}
+	duration := main.duration()
+	const audioFmt = "aformat=sample_rates=48000:channel_layouts=stereo"
+	videoFmt := fmt.Sprintf("setsar=1,fps=%g,format=yuv420p", fps)
+
+	args := []string{"-y", "-i", src}
+	var graph []string
+	next := 1
+	input := func(path string, extra ...string) int {
+		args = append(args, extra...)
+		args = append(args, "-i", path)
+		next++
+		return next - 1
+	}
+
+	mainV := "[0:v]"
+	if plan.Logo != nil {
+		idx := input(plan.Logo.Path)
+		logoW := max(2, int(float64(w)*o.LogoScale)/2*2)
+		margin := max(8, w*3/100)
+		graph = append(graph,
+			fmt.Sprintf("[%d:v]scale=%d:-1,format=rgba,colorchannelmixer=aa=%g[logo]", idx, logoW, o.LogoOpacity),
+			fmt.Sprintf("[0:v][logo]overlay=%s[logoed]", logoOverlayPos(o.LogoPosition, margin)))
+		mainV = "[logoed]"
+	}
+	graph = append(graph, mainV+videoFmt+"[mv]")
+
+	if plan.Music != nil {
+		idx := input(plan.Music.Path, "-stream_loop", "-1")
+		fade := math.Min(2, duration/4)
+		graph = append(graph,
+			fmt.Sprintf("[0:a]%s[voice]", audioFmt),
+			fmt.Sprintf("[%d:a]%s,atrim=0:%.3f,volume=eval=frame:volume='%s',afade=t=out:st=%.3f:d=%.3f[bgm]",
+				idx, audioFmt, duration, duckVolumeExpr(o.MusicAttenDB, o.MusicDuckDB, speech, 0.2), duration-fade, fade),
+			"[voice][bgm]amix=inputs=2:duration=first:dropout_transition=0:normalize=0[ma]")
+	} else {
+		graph = append(graph, fmt.Sprintf("[0:a]%s[ma]", audioFmt))
+	}
+
+	segments := []string{"[mv][ma]"}
+	bumper := func(a *composeAsset, label string) string {
+		idx := input(a.Path)
+		graph = append(graph, fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,%s[%sv]",
+			idx, w, h, w, h, videoFmt, label))
+		if a.HasAudio {
+			graph = append(graph, fmt.Sprintf("[%d:a]%s[%sa]", idx, audioFmt, label))
+		} else {
+			// 无音轨的片头片尾补静音，保证拼接时音画对齐
+			graph = append(graph, fmt.Sprintf("anullsrc=r=48000:cl=stereo,atrim=0:%.3f[%sa]", a.Duration, label))
+		}
+		return fmt.Sprintf("[%sv][%sa]", label, label)
+	}
+	if plan.Intro != nil {
+		segments = append([]string{bumper(plan.Intro, "intro")}, segments...)
+	}
+	if plan.Outro != nil {
+		segments = append(segments, bumper(plan.Outro, "outro"))
+	}
+	outV, outA := "[mv]", "[ma]"
+	if len(segments) > 1 {
+		graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=1[outv][outa]", strings.Join(segments, ""), len(segments)))
+		outV, outA = "[outv]", "[outa]"
+	}
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", outV, "-map", outA,
+		"-c:v", "libx264", "-preset", o.Preset, "-crf", strconv.Itoa(o.CRF), "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", o.AudioBitrate, "-movflags", "+faststart", dst)
+	return args, nil
+}
+
+// composeResult 按合成计划原地替换成片；失败时任务失败，结果目录保留未合成的成片
+func composeResult(ctx context.Context, status *AutoProcessStatus, plan *composePlan, videoPath string) bool {
+	status.CurrentStep = "合成背景音乐、片头片尾与台标"
+	status.Progress = 98
+	status.beginStage(stageCompose)
+	persistTaskStatus(status)
+
+	fail := func(format string, args ...any) bool {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf(format, args...)
+		return false
+	}
+	main, err := probeMedia(ctx, videoPath)
+	if err != nil {
+		return fail("读取成片信息失败: %v", err)
+	}
+	var speech [][2]float64
+	if plan.Music != nil && plan.Opts.MusicDuckDB > 0 {
+		silences, err := detectSilences(ctx, videoPath)
+		if err != nil {
+			// 检测不到人声区间时背景音乐全程按整体衰减播放
+			taskLog(ctx, status).Warn("停顿检测失败，背景音乐不做闪避", "err", err)
+		}
+		speech = speechIntervals(silences, main.duration(), 0.6)
+	}
+	work := filepath.Join(cfg.WorkDir, "compose")
+	os.MkdirAll(work, 0o755)
+	out := filepath.Join(work, status.TaskID+".mp4")
+	defer os.Remove(out)
+	args, err := composeArgs(plan, videoPath, out, main, speech)
+	if err != nil {
+		return fail("成片合成失败: %v", err)
+	}
+	if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+		return fail("成片合成失败: %v | %s", err, truncateSnippet(stderr))
+	}
+	// 先校验再替换，校验不通过时结果目录仍是未合成的成片
+	if !verifyComposed(ctx, status, plan, out) {
+		return false
+	}
+	if err := copyFile(out, videoPath); err != nil {
+		return fail("成片合成结果拷贝失败: %v", err)
+	}
+	status.Composed = true
+	var parts []string
+	for _, a := range []struct{ label, name string }{{"bgm", plan.Opts.Music}, {"intro", plan.Opts.Intro}, {"outro", plan.Opts.Outro}, {"logo", plan.Opts.Logo}} {
+		if a.name != "" {
+			parts = append(parts, a.label+"="+a.name)
+		}
+	}
+	status.noteStage(fmt.Sprintf("%s, %d 段人声", strings.Join(parts, ", "), len(speech)))
+	return true
+}
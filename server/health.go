@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthFail     = "fail"
+)
+
+type healthCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Critical 为 false 的检查失败时整体只降级，不判定为未就绪
+	Critical bool `json:"critical"`
+}
+
+type healthReport struct {
+	Status    string        `json:"status"`
+	Degraded  []string      `json:"degraded,omitempty"`
+	CheckedAt int64         `json:"checked_at"`
+	Checks    []healthCheck `json:"checks"`
+}
+
+func timedCheck(name string, critical bool, fn func() (string, error)) healthCheck {
+	started := time.Now()
+	detail, err := fn()
+	hc := healthCheck{
+		Name:      name,
+		Status:    healthOK,
+		LatencyMs: time.Since(started).Milliseconds(),
+		Detail:    detail,
+		Critical:  critical,
+	}
+	if err != nil {
+		hc.Status = healthFail
+		hc.Error = err.Error()
+	}
+	return hc
+}
+
+func checkRedis(ctx context.Context) (string, error) {
+	if redisClient == nil {
+		return "", fmt.Errorf("Redis 未初始化")
+	}
+	return "", redisClient.Ping(ctx).Err()
+}
+
+func checkRabbitMQ() (string, error) {
+	if rabbitDegraded.Load() {
+		return "", fmt.Errorf("RabbitMQ 降级中，正在重连")
+	}
+	conn, ch, pub := currentRabbitChannels()
+	if conn == nil || conn.IsClosed() {
+		return "", fmt.Errorf("RabbitMQ 连接已关闭")
+	}
+	if ch == nil || ch.IsClosed() || pub == nil || pub.IsClosed() {
+		return "", fmt.Errorf("RabbitMQ channel 已关闭")
+	}
+	return fmt.Sprintf("queue=%s", rabbitQueueName), nil
+}
+
+// degradedComponents 返回当前处于降级模式（后台重连中）的依赖
+func degradedComponents() []string {
+	var out []string
+	if redisDegraded.Load() {
+		out = append(out, "redis")
+	}
+	if rabbitDegraded.Load() {
+		out = append(out, "rabbitmq")
+	}
+	return out
+}
+
+func checkBinaryVersion(ctx context.Context, bin string) (string, error) {
+	stdout, stderr, err := run(ctx, bin, "-version")
+	if err != nil {
+		return "", fmt.Errorf("%s 不可用: %v %s", bin, err, strings.TrimSpace(stderr))
+	}
+	line, _, _ := strings.Cut(stdout, "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// checkDir 检查目录可写并返回剩余空间
+func checkDir(dir string) (string, error) {
+	probe, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return "", fmt.Errorf("目录不可写: %w", err)
+	}
+	name := probe.Name()
+	probe.Close()
+	os.Remove(name)
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return "", fmt.Errorf("读取磁盘空间失败: %w", err)
+	}
+	free := st.Bavail * uint64(st.Bsize)
+	detail := fmt.Sprintf("free=%.1fGiB", float64(free)/(1<<30))
+	if free < cfg.MinFreeDiskBytes {
+		return detail, fmt.Errorf("剩余空间不足 %.1fGiB", float64(cfg.MinFreeDiskBytes)/(1<<30))
+	}
+	return detail, nil
+}
+
+// checkHTTPReachable 只验证上游可连接（任意 HTTP 响应都视为可达）
+func checkHTTPReachable(ctx context.Context, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return fmt.Sprintf("HTTP %d", resp.StatusCode), nil
+}
+
+func checkContainerRunning(ctx context.Context, container string) (string, error) {
+	stdout, stderr, err := run(ctx, "docker", "inspect", "-f", "{{.State.Status}}", container)
+	if err != nil {
+		return "", fmt.Errorf("docker inspect 失败: %v %s", err, strings.TrimSpace(stderr))
+	}
+	state := strings.TrimSpace(stdout)
+	if state != "running" {
+		return state, fmt.Errorf("容器 %s 状态为 %s", container, state)
+	}
+	return state, nil
+}
+
+// runReadinessChecks 并发执行全部依赖检查
+func runReadinessChecks(ctx context.Context) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	type job struct {
+		name     string
+		critical bool
+		fn       func() (string, error)
+	}
+	jobs := []job{
+		{"redis", true, func() (string, error) { return checkRedis(ctx) }},
+		{"rabbitmq", true, checkRabbitMQ},
+		{"ffmpeg", true, func() (string, error) { return checkBinaryVersion(ctx, "ffmpeg") }},
+		// 素材校验与流水线都依赖 ffprobe，与 validateConfig 一致，任何模式下缺失即不可用
+		{"ffprobe", true, func() (string, error) { return checkBinaryVersion(ctx, "ffprobe") }},
+	}
+	for _, b := range cfg.TTSBackends {
+		name := "tts"
+		if len(cfg.TTSBackends) > 1 {
+			name += ":" + b.Name
+		}
+		jobs = append(jobs, job{name, false, func() (string, error) { return checkHTTPReachable(ctx, b.BaseURL) }})
+	}
+	// 多个视频后端时按名称区分检查项
+	for _, b := range cfg.VideoBackends {
+		suffix := ""
+		if len(cfg.VideoBackends) > 1 {
+			suffix = ":" + b.Name
+		}
+		jobs = append(jobs,
+			job{"video" + suffix, false, func() (string, error) { return checkHTTPReachable(ctx, b.BaseURL) }},
+			job{"gen_video_container" + suffix, false, func() (string, error) { return checkContainerRunning(ctx, b.Container) }},
+		)
+		if filepath.Clean(b.HostVideoDir) != filepath.Clean(cfg.HostVideoDir) {
+			dir := filepath.Clean(b.HostVideoDir)
+			jobs = append(jobs, job{"dir_video" + suffix, true, func() (string, error) { return checkDir(dir) }})
+		}
+	}
+	for _, d := range []struct{ name, dir string }{
+		{"dir_voice", cfg.HostVoiceDir},
+		{"dir_video", cfg.HostVideoDir},
+		{"dir_result", cfg.HostResultDir},
+	} {
+		dir := filepath.Clean(d.dir)
+		jobs = append(jobs, job{d.name, true, func() (string, error) { return checkDir(dir) }})
+	}
+
+	checks := make([]healthCheck, len(jobs))
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j job) {
+			defer wg.Done()
+			checks[i] = timedCheck(j.name, j.critical, j.fn)
+		}(i, j)
+	}
+	wg.Wait()
+
+	report := healthReport{Status: healthOK, Degraded: degradedComponents(), CheckedAt: time.Now().Unix(), Checks: checks}
+	for _, hc := range checks {
+		if hc.Status == healthOK {
+			continue
+		}
+		if hc.Critical {
+			report.Status = healthFail
+			break
+		}
+		report.Status = healthDegraded
+	}
+	return report
+}
+
+// startUpstreamProbes 周期探测视频与 TTS 后端，供调度与故障切换参考
+func startUpstreamProbes(stop context.Context) {
+	probeVideoBackends()
+	probeTTSBackends()
+	go func() {
+		ticker := time.NewTicker(upstreamProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop.Done():
+				return
+			case <-ticker.C:
+				probeVideoBackends()
+				probeTTSBackends()
+			}
+		}
+	}()
+}
+
+const upstreamProbeInterval = 15 * time.Second
+
+// GET /api/health: 存活检查，附带降级标记
+func handleHealth(c *gin.Context) {
+	c.JSON(200, gin.H{"status": "ok", "degraded": degradedComponents()})
+}
+
+// GET /api/health/ready: 依赖就绪检查，未就绪时返回 503 便于 docker-compose healthcheck
+func handleHealthReady(c *gin.Context) {
+	report := runReadinessChecks(c.Request.Context())
+	code := http.StatusOK
+	if report.Status == healthFail {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, report)
+}
@@ -4,30 +4,31 @@ import (
 	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
 	cfg             Config
-	rabbitConn      *amqp.Connection
-	rabbitChannel   *amqp.Channel
-	rabbitQueueName string
 	redisClient     *redis.Client
+	redisDegraded   atomic.Bool
 	autoTaskCounter atomic.Uint64
 )
 
@@ -37,25 +38,71 @@ func nextAutoTaskID() string {
 }
 
 func main() {
+	initLogger()
+	runMode = parseRunMode(os.Args[1:])
 	cfg = loadConfig()
+	if err := validateConfig(runMode); err != nil {
+		fatal("配置校验失败", err)
+	}
+	slog.Info("运行模式", "mode", string(runMode))
+	// 依赖不可用时以降级模式启动，后台持续重连
 	if err := initRedis(); err != nil {
-		log.Fatalf("初始化 Redis 失败: %v", err)
+		slog.Error("初始化 Redis 失败，将在后台重试", "err", err)
 	}
 	if err := initRabbitMQ(); err != nil {
-		log.Fatalf("初始化 RabbitMQ 失败: %v", err)
+		slog.Error("初始化 RabbitMQ 失败，将在后台重连", "err", err)
 	}
-	if err := loadUsers(); err != nil {
-		log.Printf("加载用户文件失败: %v (将允许空用户列表)", err)
-	} else {
-		log.Printf("已加载用户列表: 来自 %s", cfg.UsersFile)
+	if runMode.servesAPI() {
+		if err := loadUsers(); err != nil {
+			slog.Warn("加载用户文件失败，将允许空用户列表", "err", err)
+		} else {
+			slog.Info("已加载用户列表", "file", cfg.UsersFile)
+		}
 	}
 
 	if err := ensureFFmpeg(); err != nil {
-		log.Printf("警告: %v (请确保运行环境已安装 ffmpeg)", err)
+		slog.Warn("请确保运行环境已安装 ffmpeg", "err", err)
+	}
+
+	r := gin.New()
+	r.Use(gin.Recovery(), requestLogger())
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	api := r.Group("/api")
+	api.GET("/health", handleHealth)
+	api.GET("/health/ready", handleHealthReady)
+	if runMode.servesAPI() {
+		registerAPIRoutes(r)
 	}
 
-	r := gin.Default()
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	taskCtx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
+
+	startUpstreamProbes(sigCtx)
+	if runMode.runsWorker() {
+		initWorkerIdentity()
+		startWorkerHeartbeat()
+		reapStaleTasks()
+		startQueueWorker(sigCtx, taskCtx)
+	}
 
+	addr := fmt.Sprintf(":%s", cfg.Port)
+	srv := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		slog.Info("服务启动", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP 服务退出", err)
+		}
+	}()
+
+	<-sigCtx.Done()
+	gracefulShutdown(srv, cancelTasks)
+}
+
+// registerAPIRoutes 注册业务接口与前端静态资源（serve/all 模式）
+func registerAPIRoutes(r *gin.Engine) {
 	// 直通封装：与 heygem.txt 相同路径，统一从本服务调用
 	r.POST("/v1/preprocess_and_tran", handleProxyPreprocess)
 	r.POST("/v1/invoke", handleProxyInvoke)
@@ -63,7 +110,6 @@ func main() {
 
 	api := r.Group("/api")
 	{
-		api.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
 		// 认证相关
 		api.GET("/auth/me", handleAuthMe)
 		api.GET("/auth/users", handleAuthUsers)
@@ -77,6 +123,17 @@ func main() {
 		api.GET("/templates", handleTemplateList)
 		api.POST("/templates/audio", handleUploadAudioTemplate)
 		api.POST("/templates/video", handleUploadVideoTemplate)
+		api.POST("/templates/music", handleUploadMusicAsset)
+		api.POST("/templates/bumper", handleUploadBumperAsset)
+		api.POST("/templates/logo", handleUploadLogoAsset)
+		api.PUT("/templates/:kind/:name", handleTemplateReplace)
+		api.PATCH("/templates/:kind/:name", handleTemplateUpdate)
+		api.DELETE("/templates/:kind/:name", handleTemplateDelete)
+		api.GET("/templates/:kind/:name/file", handleTemplateFile)
+		api.GET("/templates/:kind/:name/thumbnail", handleTemplateThumbnail)
+		api.GET("/templates/:kind/:name/versions", handleTemplateVersions)
+		api.GET("/templates/:kind/:name/versions/diff", handleTemplateVersionDiff)
+		api.POST("/templates/:kind/:name/rollback", handleTemplateRollback)
 
 		api.POST("/tts/preprocess", handleTTSPreprocess)
 		api.POST("/tts/invoke", handleTTSInvoke)
@@ -87,10 +144,19 @@ func main() {
 		api.POST("/auto/process", handleAutoProcess)
 		api.GET("/auto/status/:taskId", handleAutoStatus)
 		api.GET("/auto/tasks", handleAutoTasks)
+		api.GET("/auto/stats/stages", handleStageLatencyReport)
 		api.POST("/auto/tasks/:taskId/retry", handleAutoRetry)
 		api.GET("/auto/archive", handleAutoArchive)
+		api.GET("/auto/renditions", handleRenditionProfiles)
+		api.GET("/render/presets", handleRenderPresetList)
+		api.PUT("/render/presets/:name", handleRenderPresetSave)
+		api.DELETE("/render/presets/:name", handleRenderPresetDelete)
 
 		api.GET("/download/video/:filename", handleDownloadVideo)
+
+		api.GET("/admin/workers", handleAdminWorkers)
+		api.GET("/admin/video-backends", handleAdminVideoBackends)
+		api.GET("/admin/tts-backends", handleAdminTTSBackends)
 	}
 
 	// 静态资源（如构建后的前端）- 使用更精确的路由避免冲突
@@ -132,28 +198,20 @@ func main() {
 				}
 				c.JSON(404, gin.H{"error": "not found"})
 			})
-			log.Printf("静态资源目录: %s", p)
+			slog.Info("静态资源目录", "dir", p)
 			break
 		}
 	}
-
-	addr := fmt.Sprintf(":%s", cfg.Port)
-	log.Printf("服务启动于 %s", addr)
-	startQueueWorker()
-	if err := r.Run(addr); err != nil {
-		log.Fatal(err)
-	}
 }
 
-// 直通封装：/v1/preprocess_and_tran -> TTS_BASE_URL/v1/preprocess_and_tran
+// 直通封装：/v1/preprocess_and_tran -> TTS 实例池 /v1/preprocess_and_tran
 func handleProxyPreprocess(c *gin.Context) {
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	url := fmt.Sprintf("%s/v1/preprocess_and_tran", cfg.TTSBaseURL)
-	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	resp, _, err := ttsRequest(c.Request.Context(), "/v1/preprocess_and_tran", body, "")
 	if err != nil {
 		c.JSON(502, gin.H{"error": err.Error()})
 		return
@@ -165,18 +223,18 @@ func handleProxyPreprocess(c *gin.Context) {
 		}
 	}
 	c.Status(resp.StatusCode)
-	io.Copy(c.Writer, resp.Body)
+	n, _ := io.Copy(c.Writer, resp.Body)
+	addBytesTransferred("proxy", n)
 }
 
-// 直通封装：/v1/invoke -> TTS_BASE_URL/v1/invoke（上游返回音频流，这里原样转发）
+// 直通封装：/v1/invoke -> TTS 实例池 /v1/invoke（上游返回音频流，这里原样转发）
 func handleProxyInvoke(c *gin.Context) {
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	url := fmt.Sprintf("%s/v1/invoke", cfg.TTSBaseURL)
-	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	resp, _, err := ttsRequest(c.Request.Context(), "/v1/invoke", body, "")
 	if err != nil {
 		c.JSON(502, gin.H{"error": err.Error()})
 		return
@@ -189,7 +247,8 @@ func handleProxyInvoke(c *gin.Context) {
 		}
 	}
 	c.Status(resp.StatusCode)
-	io.Copy(c.Writer, resp.Body)
+	n, _ := io.Copy(c.Writer, resp.Body)
+	addBytesTransferred("proxy", n)
 }
 
 // 直通封装：/easy/submit -> VIDEO_BASE_URL/easy/submit
@@ -199,7 +258,12 @@ func handleProxySubmit(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	url := fmt.Sprintf("%s/easy/submit", cfg.VideoBaseURL)
+	backend, err := videoBackendParam(c.Query("backend"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	url := fmt.Sprintf("%s/easy/submit", backend.BaseURL)
 	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
 	if err != nil {
 		c.JSON(502, gin.H{"error": err.Error()})
@@ -212,7 +276,8 @@ func handleProxySubmit(c *gin.Context) {
 		}
 	}
 	c.Status(resp.StatusCode)
-	io.Copy(c.Writer, resp.Body)
+	n, _ := io.Copy(c.Writer, resp.Body)
+	addBytesTransferred("proxy", n)
 }
 
 func handleListFiles(c *gin.Context) {
@@ -262,17 +327,19 @@ func handleUploadAudio(c *gin.Context) {
 		return
 	}
 
-	// 直接转换音频格式: MP3/其他格式 -> WAV (16kHz单声道)
+	// 转换音频格式: MP3/其他格式 -> WAV (16kHz单声道)，按处理链预处理（默认 AUDIO_FILTERS）
 	work := filepath.Join(cfg.WorkDir, "audio")
 	os.MkdirAll(work, 0o755)
 	norm := filepath.Join(work, "ref_norm.wav")
 
-	// 简单的格式转换，不做任何音频处理
-	_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", srcPath,
-		"-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", norm,
-	)
+	filters, err := audioChainFromForm(c, cfg.AudioChain.String())
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("音频格式转换失败: %v | %s", err, stderr)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	chain, _ := parseAudioChain(filters)
+	if err := convertReferenceAudio(ctx, srcPath, norm, chain); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("音频格式转换失败: %v", err)})
 		return
 	}
 
@@ -296,6 +363,7 @@ func handleUploadAudio(c *gin.Context) {
 		"copied_to":       dst,
 		"reference_audio": "ref_norm.wav",
 		"copied_to_video": dstVideo,
+		"audio_filters":   filters,
 	})
 }
 
@@ -359,8 +427,7 @@ func handleTTSPreprocess(c *gin.Context) {
 	}
 
 	body, _ := json.Marshal(req)
-	url := fmt.Sprintf("%s/v1/preprocess_and_tran", cfg.TTSBaseURL)
-	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	resp, _, err := ttsRequest(c.Request.Context(), "/v1/preprocess_and_tran", body, "")
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -409,8 +476,7 @@ func handleTTSInvoke(c *gin.Context) {
 	}
 
 	body, _ := json.Marshal(req)
-	url := fmt.Sprintf("%s/v1/invoke", cfg.TTSBaseURL)
-	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	resp, _, err := ttsRequest(c.Request.Context(), "/v1/invoke", body, "")
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -428,7 +494,9 @@ func handleTTSInvoke(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	written, err := io.Copy(f, resp.Body)
+	addBytesTransferred("tts_audio", written)
+	if err != nil {
 		f.Close()
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -462,19 +530,25 @@ func handleVideoSubmit(c *gin.Context) {
 		req.Code = fmt.Sprintf("task-%d", time.Now().Unix())
 	}
 
+	backend, err := videoBackendParam(req.Backend)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 确保文件存在于视频目录（容器应把该目录挂载为 /code/data）
-	if _, err := os.Stat(filepath.Join(cfg.HostVideoDir, req.AudioFilename)); err != nil {
+	if _, err := os.Stat(filepath.Join(backend.HostVideoDir, req.AudioFilename)); err != nil {
 		c.JSON(400, gin.H{"error": fmt.Sprintf("音频不存在: %v", err)})
 		return
 	}
-	if _, err := os.Stat(filepath.Join(cfg.HostVideoDir, req.VideoFilename)); err != nil {
+	if _, err := os.Stat(filepath.Join(backend.HostVideoDir, req.VideoFilename)); err != nil {
 		c.JSON(400, gin.H{"error": fmt.Sprintf("视频不存在: %v", err)})
 		return
 	}
 
 	payload := map[string]any{
-		"audio_url":        filepath.Join(cfg.ContainerDataRoot, req.AudioFilename),
-		"video_url":        filepath.Join(cfg.ContainerDataRoot, req.VideoFilename),
+		"audio_url":        filepath.Join(backend.DataRoot, req.AudioFilename),
+		"video_url":        filepath.Join(backend.DataRoot, req.VideoFilename),
 		"code":             req.Code,
 		"chaofen":          req.Chaofen,
 		"watermark_switch": req.WatermarkSwitch,
@@ -491,7 +565,7 @@ func handleVideoSubmit(c *gin.Context) {
 	}
 
 	body, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/easy/submit", cfg.VideoBaseURL)
+	url := fmt.Sprintf("%s/easy/submit", backend.BaseURL)
 	resp, err := httpJSON(c.Request.Context(), http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -511,8 +585,13 @@ func handleVideoResult(c *gin.Context) {
 	}
 	copyCompany := c.Query("copy_to_company") == "1"
 
-	container := cfg.GenVideoContainer
-	inside := filepath.Join(cfg.ContainerDataRoot, "temp", fmt.Sprintf("%s-r.mp4", code))
+	backend, err := videoBackendParam(c.Query("backend"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	container := backend.Container
+	inside := filepath.Join(backend.DataRoot, "temp", fmt.Sprintf("%s-r.mp4", code))
 
 	// docker exec 检查文件是否存在
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
@@ -546,6 +625,9 @@ func handleVideoResult(c *gin.Context) {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("docker cp 失败: %v | %s", err, cpErr)})
 		return
 	}
+	if st, err := os.Stat(hostOut); err == nil {
+		addBytesTransferred("docker_cp", st.Size())
+	}
 
 	companyOut := ""
 	if copyCompany {
@@ -566,52 +648,48 @@ var (
 )
 
 type queuedTask struct {
-	TaskID    string         `json:"task_id"`
-	AudioPath string         `json:"audio_path"`
-	VideoPath string         `json:"video_path"`
-	Req       AutoProcessReq `json:"req"`
+	TaskID     string         `json:"task_id"`
+	AudioPath  string         `json:"audio_path"`
+	VideoPath  string         `json:"video_path"`
+	Req        AutoProcessReq `json:"req"`
+	RequestID  string         `json:"request_id,omitempty"`
+	DispatchID string         `json:"dispatch_id,omitempty"`
 }
 
-var queueStarted bool
-
 func initRedis() error {
+	// go-redis 连接池会在命令失败后自动重建连接，这里只负责监控并标记降级状态
 	redisClient = redis.NewClient(&redis.Options{
 		Addr:     cfg.RedisAddr,
 		Password: cfg.RedisPassword,
 	})
+	go monitorRedis()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := redisClient.Ping(ctx).Err(); err != nil {
+		redisDegraded.Store(true)
 		return fmt.Errorf("连接 Redis 失败: %w", err)
 	}
 	return nil
 }
 
-func initRabbitMQ() error {
-	conn, err := amqp.Dial(cfg.RabbitURL)
-	if err != nil {
-		return fmt.Errorf("连接 RabbitMQ 失败: %w", err)
+// monitorRedis 定期 ping Redis，状态变化时记录日志并更新降级标记
+func monitorRedis() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err := redisClient.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			if !redisDegraded.Swap(true) {
+				slog.Error("Redis 不可用，进入降级模式", "err", err)
+			}
+			continue
+		}
+		if redisDegraded.Swap(false) {
+			slog.Info("Redis 已恢复")
+		}
 	}
-	ch, err := conn.Channel()
-	if err != nil {
-		conn.Close()
-		return fmt.Errorf("创建 RabbitMQ channel 失败: %w", err)
-	}
-	queueName := fmt.Sprintf("%s_tasks", cfg.QueuePrefix)
-	if _, err := ch.QueueDeclare(queueName, true, false, false, false, nil); err != nil {
-		ch.Close()
-		conn.Close()
-		return fmt.Errorf("声明 RabbitMQ 队列失败: %w", err)
-	}
-	if err := ch.Qos(1, 0, false); err != nil {
-		ch.Close()
-		conn.Close()
-		return fmt.Errorf("设置 RabbitMQ Qos 失败: %w", err)
-	}
-	rabbitConn = conn
-	rabbitChannel = ch
-	rabbitQueueName = queueName
-	return nil
 }
 
 func redisTaskStatusKey(taskID string) string {
@@ -622,19 +700,35 @@ func redisTaskIndexKey() string {
 	return fmt.Sprintf("%s:task_ids", cfg.QueuePrefix)
 }
 
+// redisProcessingTasksKey 处理中任务的 ID 集合，随状态写入维护，供回收流程扫描
+func redisProcessingTasksKey() string {
+	return fmt.Sprintf("%s:processing_tasks", cfg.QueuePrefix)
+}
+
 func persistTaskStatus(status *AutoProcessStatus) {
 	if status == nil || redisClient == nil {
 		return
 	}
+	status.UpdatedAt = time.Now().Unix()
+	if status.WorkerID != "" && status.WorkerID == workerID {
+		status.HeartbeatAt = status.UpdatedAt
+	}
 	data, err := json.Marshal(status)
 	if err != nil {
-		log.Printf("序列化任务状态失败: %v", err)
+		slog.Error("序列化任务状态失败", "task_id", status.TaskID, "err", err)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := redisClient.Set(ctx, redisTaskStatusKey(status.TaskID), data, 0).Err(); err != nil {
-		log.Printf("写入 Redis 任务状态失败: %v", err)
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, redisTaskStatusKey(status.TaskID), data, 0)
+	if status.Status == "processing" {
+		pipe.SAdd(ctx, redisProcessingTasksKey(), status.TaskID)
+	} else {
+		pipe.SRem(ctx, redisProcessingTasksKey(), status.TaskID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("写入 Redis 任务状态失败", "task_id", status.TaskID, "err", err)
 	}
 }
 
@@ -668,17 +762,22 @@ func addTaskToIndex(taskID string, start int64) {
 		Score:  float64(start),
 		Member: taskID,
 	}).Err(); err != nil {
-		log.Printf("写入任务索引失败: %v", err)
+		slog.Error("写入任务索引失败", "task_id", taskID, "err", err)
 	}
 }
 
 func listTaskStatuses() ([]*AutoProcessStatus, error) {
+	return listRecentTaskStatuses(0)
+}
+
+// listRecentTaskStatuses 按 StartTime 倒序读取最近 limit 个任务，limit <= 0 时读取全部
+func listRecentTaskStatuses(limit int) ([]*AutoProcessStatus, error) {
 	if redisClient == nil {
 		return nil, fmt.Errorf("Redis 未初始化")
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	ids, err := redisClient.ZRevRange(ctx, redisTaskIndexKey(), 0, -1).Result()
+	ids, err := redisClient.ZRevRange(ctx, redisTaskIndexKey(), 0, int64(limit)-1).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -686,7 +785,7 @@ func listTaskStatuses() ([]*AutoProcessStatus, error) {
 	for _, id := range ids {
 		status, err := loadTaskStatus(id)
 		if err != nil {
-			log.Printf("读取任务状态失败(%s): %v", id, err)
+			slog.Warn("读取任务状态失败", "task_id", id, "err", err)
 			continue
 		}
 		if status != nil {
@@ -696,23 +795,6 @@ func listTaskStatuses() ([]*AutoProcessStatus, error) {
 	return result, nil
 }
 
-func publishTask(t queuedTask) error {
-	if rabbitChannel == nil {
-		return fmt.Errorf("RabbitMQ 未初始化")
-	}
-	body, err := json.Marshal(t)
-	if err != nil {
-		return err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return rabbitChannel.PublishWithContext(ctx, "", rabbitQueueName, false, false, amqp.Publishing{
-		ContentType:  "application/json",
-		Body:         body,
-		DeliveryMode: amqp.Persistent,
-	})
-}
-
 func getOrCreateTaskStatus(taskID string) *AutoProcessStatus {
 	taskStatusMu.RLock()
 	status, ok := taskStatusMap[taskID]
@@ -722,7 +804,7 @@ func getOrCreateTaskStatus(taskID string) *AutoProcessStatus {
 	}
 	status, err := loadTaskStatus(taskID)
 	if err != nil {
-		log.Printf("从 Redis 加载任务状态失败(%s): %v", taskID, err)
+		slog.Warn("从 Redis 加载任务状态失败", "task_id", taskID, "err", err)
 	}
 	if status == nil {
 		status = &AutoProcessStatus{TaskID: taskID}
@@ -733,53 +815,6 @@ func getOrCreateTaskStatus(taskID string) *AutoProcessStatus {
 	return status
 }
 
-func startQueueWorker() {
-	if queueStarted {
-		return
-	}
-	queueStarted = true
-	go func() {
-		for {
-			if rabbitChannel == nil {
-				log.Printf("RabbitMQ 通道未就绪，3 秒后重试...")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			msgs, err := rabbitChannel.Consume(rabbitQueueName, "", false, false, false, false, nil)
-			if err != nil {
-				log.Printf("RabbitMQ 消费初始化失败: %v", err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			log.Printf("任务队列工作线程已启动，监听队列=%s", rabbitQueueName)
-			for msg := range msgs {
-				var t queuedTask
-				if err := json.Unmarshal(msg.Body, &t); err != nil {
-					log.Printf("解析任务消息失败: %v", err)
-					msg.Nack(false, false)
-					continue
-				}
-				status := getOrCreateTaskStatus(t.TaskID)
-				if status.StartTime == 0 {
-					status.StartTime = time.Now().Unix()
-				}
-				status.Status = "processing"
-				status.CurrentStep = "排队完成，开始处理"
-				if status.Progress < 5 {
-					status.Progress = 5
-				}
-				persistTaskStatus(status)
-				processAutomatically(context.Background(), t.TaskID, t.AudioPath, t.VideoPath, t.Req)
-				if err := msg.Ack(false); err != nil {
-					log.Printf("确认 RabbitMQ 消息失败: %v", err)
-				}
-			}
-			log.Printf("RabbitMQ 消费通道已关闭，5 秒后重试...")
-			time.Sleep(5 * time.Second)
-		}
-	}()
-}
-
 // /api/auto/process: 全自动化处理接口
 func handleAutoProcess(c *gin.Context) {
 	// 登录校验
@@ -818,30 +853,92 @@ func handleAutoProcess(c *gin.Context) {
 		err       error
 	)
 
+	videoPrep, err := videoPrepFromForm(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.VideoPrep = &videoPrep
+	if req.Subtitles, err = subtitleOptionsFromForm(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Compose, err = composeOptionsFromForm(c, loginUser); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Renditions, err = renditionsFromForm(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var audioTemplatePath string
 	if req.AudioTemplateName != "" {
-		_, path, err := findTemplateItem(templateKindAudio, req.AudioTemplateName)
+		item, path, err := findTemplateItem(templateKindAudio, req.AudioTemplateName)
+		if err == nil && !templateVisible(item, loginUser) {
+			err = fmt.Errorf("模板 %s 未找到", req.AudioTemplateName)
+		}
+		if v := strings.TrimSpace(c.PostForm("audio_template_version")); err == nil && v != "" {
+			var version int
+			if version, err = strconv.Atoi(v); err == nil {
+				item, path, err = pinTemplateVersion(templateKindAudio, item, version)
+			}
+		}
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("音频模版无效: %v", err)})
 			return
 		}
 		audioTemplatePath = path
+		req.AudioTemplateVersion = item.Version
+		// 参考音频处理链默认沿用模版的处理链
+		if req.AudioFilters, err = audioChainFromForm(c, item.AudioFilters); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 产物按模版处理链生成，请求指定了不同的处理链时改用模版原始媒体现场处理
+		if prepared, ok := templatePipelineInput(templateKindAudio, item); ok && req.AudioFilters == item.AudioFilters {
+			audioTemplatePath = prepared
+			req.AudioPrepared = true
+		}
 	} else {
 		audioFile, err = c.FormFile("audio")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少音频文件或模版"})
 			return
 		}
+		// 参考音频处理链默认为 AUDIO_FILTERS
+		if req.AudioFilters, err = audioChainFromForm(c, cfg.AudioChain.String()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	var videoTemplatePath string
+	var (
+		videoTemplatePath string
+		templateRender    *RenderPreset
+	)
 	if req.VideoTemplateName != "" {
-		_, path, err := findTemplateItem(templateKindVideo, req.VideoTemplateName)
+		item, path, err := findTemplateItem(templateKindVideo, req.VideoTemplateName)
+		if err == nil && !templateVisible(item, loginUser) {
+			err = fmt.Errorf("模板 %s 未找到", req.VideoTemplateName)
+		}
+		if v := strings.TrimSpace(c.PostForm("video_template_version")); err == nil && v != "" {
+			var version int
+			if version, err = strconv.Atoi(v); err == nil {
+				item, path, err = pinTemplateVersion(templateKindVideo, item, version)
+			}
+		}
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("视频模版无效: %v", err)})
 			return
 		}
 		videoTemplatePath = path
+		req.VideoTemplateVersion = item.Version
+		templateRender = item.Render
+		if prepared, ok := templatePipelineInput(templateKindVideo, item); ok {
+			videoTemplatePath = prepared
+			req.VideoPrepared = true
+		}
 	} else {
 		videoFile, err = c.FormFile("video")
 		if err != nil {
@@ -850,70 +947,96 @@ func handleAutoProcess(c *gin.Context) {
 		}
 	}
 
-	log.Printf("解析的请求参数: TaskName=%s, Speaker=%s, Text=%s, CopyToCompany=%v, UseTTS=%v, AudioTemplate=%s, VideoTemplate=%s", req.TaskName, req.Speaker, req.Text, req.CopyToCompany, req.UseTTS, req.AudioTemplateName, req.VideoTemplateName)
+	render, preset, err := resolveRenderOptions(c, loginUser, templateRender)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Render, req.RenderPreset = &render, preset
 
 	taskID := nextAutoTaskID()
-	status := &AutoProcessStatus{
-		TaskID:      taskID,
-		TaskName:    req.TaskName,
-		Username:    loginUser,
-		Status:      "processing",
-		CurrentStep: "上传文件",
-		Progress:    0,
-		StartTime:   time.Now().Unix(),
-	}
-	status.Request = &req
-	taskStatusMu.Lock()
-	taskStatusMap[taskID] = status
-	taskStatusMu.Unlock()
-	addTaskToIndex(taskID, status.StartTime)
-	persistTaskStatus(status)
+	lg := loggerFrom(c.Request.Context()).With("task_id", taskID)
+	lg.Info("解析的请求参数",
+		"task_name", req.TaskName,
+		"speaker", req.Speaker,
+		"text", req.Text,
+		"copy_to_company", req.CopyToCompany,
+		"use_tts", req.UseTTS,
+		"audio_template", req.AudioTemplateName,
+		"video_template", req.VideoTemplateName,
+		"render", render,
+	)
 
 	var audioPath string
 	if audioTemplatePath != "" {
 		audioPath = audioTemplatePath
-		log.Printf("使用音频模版: %s", audioPath)
+		lg.Info("使用音频模版", "path", audioPath)
 	} else {
-		log.Printf("开始保存音频文件: %s", audioFile.Filename)
-		audioPath, err = saveMultipartFile(audioFile, filepath.Join(cfg.WorkDir, "upload"), "ref.wav")
+		lg.Info("开始保存音频文件", "filename", audioFile.Filename)
+		audioPath, err = saveMultipartFile(audioFile, filepath.Join(cfg.WorkDir, "upload"), taskFileName(taskID, "ref.wav"))
 		if err != nil {
-			log.Printf("音频保存失败: %v", err)
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("音频上传失败: %v", err)
-			persistTaskStatus(status)
-			c.JSON(500, gin.H{"error": status.Error})
+			lg.Error("音频保存失败", "err", err)
+			c.JSON(500, gin.H{"error": fmt.Sprintf("音频上传失败: %v", err)})
 			return
 		}
-		log.Printf("音频文件保存成功: %s", audioPath)
+		lg.Info("音频文件保存成功", "path", audioPath)
 	}
-	status.AudioPath = audioPath
 
 	var videoPath string
 	if videoTemplatePath != "" {
 		videoPath = videoTemplatePath
-		log.Printf("使用视频模版: %s", videoPath)
+		lg.Info("使用视频模版", "path", videoPath)
 	} else {
-		log.Printf("开始保存视频文件: %s", videoFile.Filename)
-		videoPath, err = saveMultipartFile(videoFile, filepath.Join(cfg.WorkDir, "upload"), "video.mp4")
+		lg.Info("开始保存视频文件", "filename", videoFile.Filename)
+		videoPath, err = saveMultipartFile(videoFile, filepath.Join(cfg.WorkDir, "upload"), taskFileName(taskID, "video.mp4"))
 		if err != nil {
-			log.Printf("视频保存失败: %v", err)
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("视频上传失败: %v", err)
-			persistTaskStatus(status)
-			c.JSON(500, gin.H{"error": status.Error})
+			lg.Error("视频保存失败", "err", err)
+			c.JSON(500, gin.H{"error": fmt.Sprintf("视频上传失败: %v", err)})
 			return
 		}
-		log.Printf("视频文件保存成功: %s", videoPath)
+		lg.Info("视频文件保存成功", "path", videoPath)
+	}
+
+	// 入队前同步校验，避免无效素材在 worker 的 ffmpeg 阶段才失败
+	if issues := validateAutoInputs(c.Request.Context(), req, audioPath, videoPath); len(issues) > 0 {
+		lg.Warn("任务素材校验未通过", "issues", issues)
+		// 未入队的任务不会再使用上传的素材
+		if audioTemplatePath == "" {
+			os.Remove(audioPath)
+		}
+		if videoTemplatePath == "" {
+			os.Remove(videoPath)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "输入校验失败: " + issues[0].Message, "issues": issues})
+		return
 	}
+
+	status := &AutoProcessStatus{
+		TaskID:      taskID,
+		TaskName:    req.TaskName,
+		Username:    loginUser,
+		Status:      "processing",
+		CurrentStep: "上传文件",
+		Progress:    0,
+		StartTime:   time.Now().Unix(),
+	}
+	status.Request = &req
+	status.AudioPath = audioPath
 	status.VideoPath = videoPath
+	taskStatusMu.Lock()
+	taskStatusMap[taskID] = status
+	taskStatusMu.Unlock()
+	addTaskToIndex(taskID, status.StartTime)
 	persistTaskStatus(status)
 
 	status.Status = "queued"
 	status.CurrentStep = "等待排队执行"
-	persistTaskStatus(status)
-	if err := publishTask(queuedTask{TaskID: taskID, AudioPath: audioPath, VideoPath: videoPath, Req: req}); err != nil {
+	status.beginStage(stageQueueWait)
+	if err := enqueueTask(status, queuedTask{TaskID: taskID, AudioPath: audioPath, VideoPath: videoPath, Req: req, RequestID: requestIDFrom(c.Request.Context())}); err != nil {
 		status.Status = "failed"
 		status.Error = fmt.Sprintf("任务入队失败: %v", err)
+		status.endStage(stageOutcomeFailed, status.Error)
+		observeTaskOutcome(status)
 		persistTaskStatus(status)
 		c.JSON(503, gin.H{"error": status.Error})
 		return
@@ -930,6 +1053,18 @@ func handleUploadVideoTemplate(c *gin.Context) {
 	uploadTemplateWithName(c, templateKindVideo)
 }
 
+func handleUploadMusicAsset(c *gin.Context) {
+	uploadTemplateWithName(c, templateKindMusic)
+}
+
+func handleUploadBumperAsset(c *gin.Context) {
+	uploadTemplateWithName(c, templateKindBumper)
+}
+
+func handleUploadLogoAsset(c *gin.Context) {
+	uploadTemplateWithName(c, templateKindLogo)
+}
+
 func uploadTemplateWithName(c *gin.Context, kind string) {
 	name := strings.TrimSpace(c.PostForm("name"))
 	if name == "" {
@@ -952,721 +1087,211 @@ func uploadTemplateWithName(c *gin.Context, kind string) {
 		displayName = originalBase
 	}
 
+	loginUser := usernameFromContext(c)
 	sanitized := sanitizeTemplateKey(name)
-	if err := ensureTemplateKindDir(kind); err != nil {
+	base, exists, err := lookupTemplateItem(kind, sanitized)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	tmpDir := filepath.Join(cfg.WorkDir, "tmp")
-	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if exists && !templateWritable(base, loginUser) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("模版标识 %s 已被其他用户占用", sanitized)})
 		return
 	}
-	tmpName := sanitized + filepath.Ext(file.Filename)
-	tmpPath, err := saveMultipartFile(file, tmpDir, tmpName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版暂存失败: %v", err)})
-		return
+	if !exists {
+		base = TemplateItem{Name: sanitized, Owner: loginUser, Shared: true}
+	}
+	base.DisplayName = displayName
+	base.Kind = kind
+	attrs, err := templateAttrsFromForm(c)
+	if err == nil {
+		err = applyTemplateAttrs(&base, attrs)
 	}
-	defer os.Remove(tmpPath)
-
-	finalPath, err := templateFilePath(kind, sanitized)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx := c.Request.Context()
-	switch kind {
-	case templateKindAudio:
-		_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", tmpPath,
-			"-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", finalPath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("音频模版转换失败: %v | %s", err, stderr)})
-			return
-		}
-	case templateKindVideo:
-		_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", tmpPath, "-c:v", "copy", "-c:a", "copy", finalPath)
-		if err != nil {
-			log.Printf("视频模版快速转封装失败，尝试重新编码: %v | %s", err, stderr)
-			_, stderr, err = run(ctx, "ffmpeg", "-y", "-i", tmpPath,
-				"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
-				"-c:a", "aac", "-b:a", "192k", finalPath)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("视频模版转换失败: %v | %s", err, stderr)})
-				return
-			}
-		}
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的模版类型"})
-		return
-	}
-
-	item := TemplateItem{
-		Name:         sanitized,
-		DisplayName:  displayName,
-		OriginalName: file.Filename,
-		Kind:         kind,
-		UpdatedAt:    time.Now().Unix(),
-	}
-	if err := upsertTemplateItem(kind, item); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版信息保存失败: %v", err)})
-		return
-	}
-
-	c.JSON(200, gin.H{"message": "模版已更新", "template": item})
+	saveTemplateUpload(c, kind, base, file)
 }
 
-func handleTemplateList(c *gin.Context) {
-	kind := strings.TrimSpace(c.Query("kind"))
-	switch kind {
-	case "", templateKindAudio, templateKindVideo:
-		// ok
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "kind 仅支持 audio|video"})
-		return
-	}
-
-	if kind == "" {
-		audio, err := listTemplates(templateKindAudio)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		video, err := listTemplates(templateKindVideo)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(200, gin.H{"audio": audio, "video": video})
+// saveTemplateUpload 转换上传文件并写入模版 base.Name（新建或替换媒体），随后生成产物并更新索引；
+// base 携带显示名称、归属、标签等属性
+func saveTemplateUpload(c *gin.Context, kind string, base TemplateItem, file *multipart.FileHeader) {
+	sanitized := base.Name
+	if err := ensureTemplateKindDir(kind); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	items, err := listTemplates(kind)
-	if err != nil {
+	tmpDir := filepath.Join(cfg.WorkDir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, gin.H{kind: items})
-}
-
-func parseBool(v string) bool {
-	switch strings.ToLower(strings.TrimSpace(v)) {
-	case "1", "true", "yes", "on":
-		return true
-	default:
-		return false
+	// 暂存文件名唯一，同一模版的并发上传互不覆盖
+	tmpPath, err := stageMultipartFile(file, tmpDir, sanitized+"-*"+sanitizeFilename(filepath.Ext(file.Filename)))
+	if tmpPath != "" {
+		defer os.Remove(tmpPath)
 	}
-}
-
-// 异步自动化处理函数
-func processAutomatically(ctx context.Context, taskID string, audioPath, videoPath string, req AutoProcessReq) {
-	status := getOrCreateTaskStatus(taskID)
-	if req.TaskName == "" {
-		req.TaskName = fmt.Sprintf("task-%s", taskID)
-	}
-	if status.TaskName == "" {
-		status.TaskName = req.TaskName
-	}
-	var body []byte
-	var url string
-	var resp *http.Response
-	defer func() {
-		if r := recover(); r != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("处理异常: %v", r)
-			status.Progress = 0
-		}
-		// 确保失败场景记录结束时间与耗时
-		if status.Status == "failed" && status.EndTime == 0 {
-			status.EndTime = time.Now().Unix()
-			status.TotalDuration = status.EndTime - status.StartTime
-		}
-		persistTaskStatus(status)
-	}()
-
-	// 创建新的上下文，避免HTTP请求上下文被取消
-	processCtx := context.Background()
-
-	// 步骤1: 处理音频 (10%)
-	status.CurrentStep = "处理音频文件"
-	status.Progress = 10
-	persistTaskStatus(status)
-
-	// 直接转换音频格式: MP3/其他格式 -> WAV (16kHz单声道)
-	work := filepath.Join(cfg.WorkDir, "audio")
-	os.MkdirAll(work, 0o755)
-	norm := filepath.Join(work, "ref_norm.wav")
-
-	// 简单的格式转换，不做任何音频处理
-	_, stderr, err := run(processCtx, "ffmpeg", "-y", "-i", audioPath,
-		"-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", norm,
-	)
 	if err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("音频格式转换失败: %v | %s", err, stderr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版暂存失败: %v", err)})
 		return
 	}
 
-	// 拷贝到 voice/data 目录
-	dst := filepath.Join(cfg.HostVoiceDir, "ref_norm.wav")
-	if err := copyFile(norm, dst); err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("音频拷贝失败: %v", err)
+	if _, err := templateFilePath(kind, sanitized); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// 同步拷贝到视频目录，便于“自带音频”链路直接使用
-	dstInVideo := filepath.Join(cfg.HostVideoDir, "ref_norm.wav")
-	if err := copyFile(norm, dstInVideo); err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("音频拷贝到视频目录失败: %v", err)
+	// 每次上传写入新的不可变版本，已提交的任务仍使用各自固定的版本
+	versions, err := loadTemplateVersions(kind, sanitized)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取模版版本失败: %v", err)})
 		return
 	}
-
-	// 步骤2: 处理视频 (20%)
-	status.CurrentStep = "处理视频文件"
-	status.Progress = 20
-	persistTaskStatus(status)
-
-	// 视频静音处理
-	silentPath := filepath.Join(cfg.WorkDir, "video", "silent.mp4")
-	os.MkdirAll(filepath.Dir(silentPath), 0o755)
-	_, stderr, err = run(processCtx, "ffmpeg", "-y", "-i", videoPath, "-an", "-c:v", "copy", silentPath)
+	var legacy *TemplateVersion
+	if len(versions) == 0 && base.UpdatedAt > 0 && base.Version == 0 {
+		v := legacyTemplateVersion(base)
+		legacy = &v
+	}
+	floor := 0
+	for _, v := range versions {
+		floor = max(floor, v.Version)
+	}
+	version, err := reserveTemplateVersion(kind, sanitized, floor)
 	if err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("视频静音失败: %v | %s", err, stderr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("分配模版版本失败: %v", err)})
 		return
 	}
-
-	// 拷贝到 face2face 目录
-	dstVideo := filepath.Join(cfg.HostVideoDir, "silent.mp4")
-	if err := copyFile(silentPath, dstVideo); err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("视频拷贝失败: %v", err)
+	finalPath := templateMediaPath(kind, sanitized, version, "")
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	// 如果使用自带音频，跳过 TTS 流程（稍后直接用 ref_norm.wav 作为合成音频）
-	// 否则执行 TTS 预处理 + 合成
-	audioForVideo := "ref_norm.wav" // 默认自带音频文件名
-	if req.UseTTS {
-		// 步骤3: TTS预处理 (30%)
-		status.CurrentStep = "TTS预处理"
-		status.Progress = 30
-		persistTaskStatus(status)
-
-		preprocessReq := PreprocessReq{
-			Format:         "wav",
-			ReferenceAudio: "ref_norm.wav",
-			Lang:           "zh",
+	// 版本文件不可变：ffmpeg 以 -n 拒绝覆盖，转换失败时删除残留的半成品
+	converted := false
+	defer func() {
+		if !converted {
+			os.Remove(finalPath)
 		}
+	}()
 
-		body, _ := json.Marshal(preprocessReq)
-		url := fmt.Sprintf("%s/v1/preprocess_and_tran", cfg.TTSBaseURL)
-		resp, err := httpJSON(processCtx, http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	ctx := c.Request.Context()
+	switch kind {
+	case templateKindAudio:
+		_, stderr, err := run(ctx, "ffmpeg", "-n", "-i", tmpPath,
+			"-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", finalPath)
 		if err != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS预处理失败: %v", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			b, _ := io.ReadAll(resp.Body)
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS预处理失败: %s", string(b))
-			return
-		}
-
-		var preResp PreprocessResp
-		if err := json.NewDecoder(resp.Body).Decode(&preResp); err != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS预处理解析失败: %v", err)
-			return
-		}
-		// 预处理可能以 HTTP 200 + code != 0 的方式返回失败，需要显式拦截
-		if preResp.Code != 0 || preResp.ASRFormatAudioURL == "" || preResp.ReferenceAudioText == "" {
-			status.Status = "failed"
-			// 将上游 msg 透出，便于定位（典型：asr failed）
-			status.Error = fmt.Sprintf("TTS预处理失败: code=%d, msg=%s", preResp.Code, preResp.Msg)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("音频模版转换失败: %v | %s", err, stderr)})
 			return
 		}
-
-		log.Printf("TTS预处理响应: ReferenceAudio=%s, ReferenceText=%s", preResp.ASRFormatAudioURL, preResp.ReferenceAudioText)
-
-		// 步骤4: TTS合成 (50%)
-		status.CurrentStep = "TTS语音合成"
-		status.Progress = 50
-		persistTaskStatus(status)
-
-		if req.Speaker == "" {
-			req.Speaker = "demo001"
-		}
-
-		// 使用map构建请求，避免结构体问题
-		ttsReq := map[string]interface{}{
-			"speaker":            req.Speaker,
-			"text":               req.Text,
-			"format":             "wav",
-			"topP":               0.7,
-			"max_new_tokens":     1024,
-			"chunk_length":       100,
-			"repetition_penalty": 1.2,
-			"temperature":        0.7,
-			"need_asr":           false,
-			"streaming":          false,
-			"is_fixed_seed":      0,
-			"is_norm":            0,
-			"reference_audio":    preResp.ASRFormatAudioURL,
-			"reference_text":     preResp.ReferenceAudioText,
+	case templateKindVideo:
+		_, stderr, err := run(ctx, "ffmpeg", "-n", "-i", tmpPath, "-c:v", "copy", "-c:a", "copy", finalPath)
+		if err != nil {
+			loggerFrom(ctx).Warn("视频模版快速转封装失败，尝试重新编码", "template", sanitized, "err", err, "stderr", stderr)
+			os.Remove(finalPath)
+			_, stderr, err = run(ctx, "ffmpeg", "-n", "-i", tmpPath,
+				"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
+				"-c:a", "aac", "-b:a", "192k", finalPath)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("视频模版转换失败: %v | %s", err, stderr)})
+				return
+			}
 		}
-
-		body, _ = json.Marshal(ttsReq)
-		log.Printf("TTS请求内容: %s", string(body))
-		url = fmt.Sprintf("%s/v1/invoke", cfg.TTSBaseURL)
-		resp, err = httpJSON(processCtx, http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	case templateKindMusic:
+		// 背景音乐统一为 48kHz 立体声 AAC，便于合成时直接混音
+		_, stderr, err := run(ctx, "ffmpeg", "-n", "-i", tmpPath, "-vn",
+			"-ar", "48000", "-ac", "2", "-c:a", "aac", "-b:a", "192k", finalPath)
 		if err != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS合成失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("背景音乐转换失败: %v | %s", err, stderr)})
 			return
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			b, _ := io.ReadAll(resp.Body)
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS合成失败: %s", string(b))
+	case templateKindBumper:
+		// 片头片尾统一编码，合成时只需缩放拼接
+		args := []string{"-n", "-i", tmpPath}
+		args = append(args, x264Args...)
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-ar", "48000", "-ac", "2", finalPath)
+		if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("片头片尾转换失败: %v | %s", err, stderr)})
 			return
 		}
-
-		// 保存TTS生成的音频
-		outVoice := filepath.Join(cfg.HostVoiceDir, sanitizeFilename(req.Speaker)+".wav")
-		f, err := os.Create(outVoice)
+	case templateKindLogo:
+		// 台标取第一帧转为 PNG，保留透明通道
+		_, stderr, err := run(ctx, "ffmpeg", "-n", "-i", tmpPath, "-frames:v", "1", "-pix_fmt", "rgba", finalPath)
 		if err != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS音频保存失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("台标转换失败: %v | %s", err, stderr)})
 			return
 		}
-		if _, err := io.Copy(f, resp.Body); err != nil {
-			f.Close()
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS音频写入失败: %v", err)
-			return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的模版类型"})
+		return
+	}
+	converted = true
+
+	item := base
+	item.OriginalName = file.Filename
+	item.Kind = kind
+	item.UpdatedAt = time.Now().Unix()
+	item.Version = version
+	item.Prepared = false
+	item.Meta = nil
+	if kind == templateKindAudio {
+		// 处理链随版本固定：未指定时沿用上一版本，新模版使用 TEMPLATE_AUDIO_FILTERS
+		raw, ok := c.GetPostForm("audio_filters")
+		if !ok {
+			raw = item.AudioFilters
 		}
-		f.Close()
-
-		// 复制到视频目录
-		outInVideo := filepath.Join(cfg.HostVideoDir, filepath.Base(outVoice))
-		if err := copyFile(outVoice, outInVideo); err != nil {
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("TTS音频拷贝失败: %v", err)
+		if !ok && raw == "" {
+			raw = cfg.TemplateAudioChain.String()
+		}
+		chain, err := parseAudioChain(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		// 设置将要用于视频合成的音频文件名（容器内路径拼接时只需要文件名）
-		audioForVideo = filepath.Base(outVoice)
+		item.AudioFilters = chain.String()
 	}
-
-	// 步骤5: 视频合成提交 (70%)
-	status.CurrentStep = "提交视频合成任务"
-	status.Progress = 70
-	persistTaskStatus(status)
-
-	taskCode := req.TaskName
-	if taskCode == "" {
-		taskCode = fmt.Sprintf("task-%s", taskID)
-	}
-	status.TaskName = taskCode
-	containerResultName := fmt.Sprintf("%s-r.mp4", taskCode)
-	resultFilename := fmt.Sprintf("%s.mp4", taskCode)
-	containerResultPath := filepath.Join(cfg.ContainerDataRoot, "temp", containerResultName)
-	payload := map[string]any{
-		"audio_url":        filepath.Join(cfg.ContainerDataRoot, audioForVideo),
-		"video_url":        filepath.Join(cfg.ContainerDataRoot, "silent.mp4"),
-		"code":             taskCode,
-		"chaofen":          0,
-		"watermark_switch": 0,
-		"pn":               1,
-	}
-
-	body, _ = json.Marshal(payload)
-	url = fmt.Sprintf("%s/easy/submit", cfg.VideoBaseURL)
-	resp, err = httpJSON(processCtx, http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
-	if err != nil {
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("视频合成提交失败: %v", err)
+	// 记录媒体信息，便于按时长、分辨率等挑选模版
+	if probe, err := probeMedia(ctx, finalPath); err != nil {
+		loggerFrom(ctx).Warn("模版媒体信息探测失败", "template", sanitized, "err", err)
+	} else {
+		item.Meta = templateMetaFromProbe(probe)
+	}
+	// 预先生成流水线产物，任务直接使用；失败时不影响模版本身，流水线回退为现场转换
+	resp := gin.H{"message": "模版已更新"}
+	if err := buildTemplateDerivatives(ctx, kind, finalPath, &item); err != nil {
+		loggerFrom(ctx).Warn("模版预处理失败", "template", sanitized, "version", version, "err", err)
+		removeTemplateDerivatives(kind, sanitized, version)
+		resp["warning"] = fmt.Sprintf("模版预处理失败，任务将现场转换: %v", err)
+	}
+	if err := appendTemplateVersion(kind, sanitized, TemplateVersion{
+		Version:      version,
+		OriginalName: item.OriginalName,
+		CreatedAt:    item.UpdatedAt,
+		CreatedBy:    usernameFromContext(c),
+		Prepared:     item.Prepared,
+		Meta:         item.Meta,
+		AudioFilters: item.AudioFilters,
+		Render:       item.Render,
+	}, legacy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版版本保存失败: %v", err)})
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("视频合成提交失败: %s", string(b))
+	if err := upsertTemplateItem(kind, item); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版信息保存失败: %v", err)})
 		return
 	}
 
-	// 步骤6: 轮询视频合成结果 (70-100%)
-	status.CurrentStep = "等待视频合成完成"
-	status.Progress = 80
-	persistTaskStatus(status)
-
-	// 开始轮询，间隔30秒
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	maxWait := cfg.VideoWaitTimeout
-	if maxWait <= 0 {
-		maxWait = 15 * time.Minute
-	}
-	timeout := time.After(maxWait)
-	checkCount := 0
-
-	for {
-		select {
-		case <-ticker.C:
-			checkCount++
-			// 检查视频是否生成完成
-			inside := containerResultPath
-
-			// 更新状态信息
-			status.CurrentStep = fmt.Sprintf("等待视频合成完成 (已检查 %d 次，约 %d 分钟，最多等待约 %.1f 分钟)", checkCount, checkCount/2, maxWait.Minutes())
-			persistTaskStatus(status)
-
-			// 优先通过宿主机挂载目录检测结果文件，避免依赖 docker 命令
-			resultName := containerResultName
-			srcOnHost := filepath.Join(cfg.HostVideoDir, "temp", resultName)
-			hostOut := filepath.Join(cfg.HostResultDir, resultFilename)
-			if st, err := os.Stat(srcOnHost); err == nil && !st.IsDir() {
-				log.Printf("检测到结果文件: %s (size=%d)", srcOnHost, st.Size())
-				// 等待大小稳定（连续3次相同，每次间隔3s）
-				last := st.Size()
-				stable := 1
-				for i := 2; i <= 3; i++ {
-					time.Sleep(3 * time.Second)
-					st2, e2 := os.Stat(srcOnHost)
-					if e2 != nil {
-						log.Printf("稳定性检查失败: %v", e2)
-						break
-					}
-					cur := st2.Size()
-					log.Printf("稳定性检查 #%d: %d bytes", i, cur)
-					if cur == last {
-						stable++
-					} else {
-						last = cur
-						stable = 1
-					}
-				}
-				if stable >= 3 {
-					// 复制并校验
-					copyOK := false
-					for attempt := 1; attempt <= 3; attempt++ {
-						log.Printf("=== 复制尝试(本地) #%d (src=%s -> dst=%s) ===", attempt, srcOnHost, hostOut)
-						if err := copyFile(srcOnHost, hostOut); err != nil {
-							log.Printf("复制失败: %v", err)
-							if attempt < 3 {
-								time.Sleep(5 * time.Second)
-								continue
-							}
-						} else {
-							if dstStat, e3 := os.Stat(hostOut); e3 == nil && dstStat.Size() == last {
-								log.Printf("✅ 文件复制成功，大小匹配: %d bytes", last)
-								copyOK = true
-								break
-							}
-							log.Printf("❌ 复制后大小不匹配或读取失败")
-							if attempt < 3 {
-								time.Sleep(5 * time.Second)
-								continue
-							}
-						}
-					}
-					if !copyOK {
-						status.Status = "failed"
-						status.Error = "视频拷贝到结果目录失败，已重试3次"
-						return
-					}
-					// 可选拷贝到公司目录
-					if req.CopyToCompany {
-						companyOut := filepath.Join(cfg.WindowsCompanyDir, resultFilename)
-						log.Printf("复制到公司目录: %s", companyOut)
-						if err := copyFile(hostOut, companyOut); err != nil {
-							log.Printf("拷贝到公司目录失败: %v", err)
-						}
-					}
-					// 完成
-					status.Status = "completed"
-					status.CurrentStep = "处理完成"
-					status.Progress = 100
-					status.ResultVideo = resultFilename
-					status.ResultPath = hostOut
-					status.EndTime = time.Now().Unix()
-					status.TotalDuration = status.EndTime - status.StartTime
-					log.Printf("任务 %s 完成，总耗时: %d 秒 (%.1f 分钟)", taskID, status.TotalDuration, float64(status.TotalDuration)/60)
-					return
-				} else {
-					log.Printf("文件大小未稳定，继续等待...")
-				}
-			}
-
-			// 检查文件是否存在且写入完成
-			checkCmd := fmt.Sprintf("docker exec -i %s bash -lc 'if [ -f \"%s\" ]; then echo FOUND; else echo MISSING; fi'", cfg.GenVideoContainer, inside)
-			log.Printf("执行文件检查命令: %s", checkCmd)
-
-			checkCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			stdout, stderr, err := run(checkCtx, "docker", "exec", "-i", cfg.GenVideoContainer, "bash", "-lc", fmt.Sprintf("if [ -f '%s' ]; then echo FOUND; else echo MISSING; fi", inside))
-			cancel()
-
-			// 静默检查，只在出错时记录日志
-			if err != nil {
-				log.Printf("视频合成检查 #%d 出错: 文件路径=%s, 错误=%v, stdout=%s, stderr=%s", checkCount, inside, err, stdout, stderr)
-			}
-
-			// 检查stdout和stderr中是否包含FOUND
-			if err == nil && (strings.Contains(stdout, "FOUND") || strings.Contains(stderr, "FOUND")) {
-				// 文件存在，但需要检查是否还在写入
-				log.Printf("文件已存在，检查是否还在写入...")
-
-				// 等待文件稳定 - 检查文件大小是否还在变化
-				var lastSize string
-				var stableCount int
-
-				for stabilityCheck := 1; stabilityCheck <= 5; stabilityCheck++ {
-					sizeCmd := fmt.Sprintf("docker exec -i %s stat -c %%s %s", cfg.GenVideoContainer, inside)
-					log.Printf("稳定性检查 #%d: %s", stabilityCheck, sizeCmd)
-
-					sizeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-					sizeOut, _, sizeErr := run(sizeCtx, "docker", "exec", "-i", cfg.GenVideoContainer, "stat", "-c", "%s", inside)
-					cancel()
-
-					if sizeErr != nil {
-						log.Printf("稳定性检查失败: %v", sizeErr)
-						break
-					}
-
-					currentSize := strings.TrimSpace(sizeOut)
-					log.Printf("当前文件大小: %s bytes", currentSize)
-
-					if stabilityCheck == 1 {
-						lastSize = currentSize
-						stableCount = 1
-					} else if currentSize == lastSize {
-						stableCount++
-						log.Printf("文件大小稳定 (连续%d次相同)", stableCount)
-						if stableCount >= 3 {
-							log.Printf("✅ 文件写入完成，大小稳定: %s bytes", currentSize)
-							break
-						}
-					} else {
-						log.Printf("文件大小仍在变化: %s -> %s", lastSize, currentSize)
-						lastSize = currentSize
-						stableCount = 1
-					}
-
-					if stabilityCheck < 5 {
-						time.Sleep(3 * time.Second)
-					}
-				}
-
-				// 如果文件稳定了，继续处理
-				if stableCount >= 3 {
-					// 视频生成完成
-					status.CurrentStep = "下载最终视频"
-					status.Progress = 95
-					persistTaskStatus(status)
-
-					// 拷贝到结果目录 - 带重试和完整性检查
-					hostOut := filepath.Join(cfg.HostResultDir, resultFilename)
-					if err := os.MkdirAll(filepath.Dir(hostOut), 0o755); err != nil {
-						status.Status = "failed"
-						status.Error = fmt.Sprintf("创建结果目录失败: %v", err)
-						return
-					}
-
-					// 获取容器中文件的大小 - 等待文件稳定
-					log.Printf("开始检查容器文件: %s", inside)
-
-					// 等待文件大小稳定（最多等待30秒）
-					var expectedSize string
-					for waitAttempt := 1; waitAttempt <= 6; waitAttempt++ {
-						sizeCmd := fmt.Sprintf("docker exec -i %s stat -c %%s %s", cfg.GenVideoContainer, inside)
-						log.Printf("执行命令: %s", sizeCmd)
-
-						sizeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-						sizeOut, _, err := run(sizeCtx, "docker", "exec", "-i", cfg.GenVideoContainer, "stat", "-c", "%s", inside)
-						cancel()
-						if err != nil {
-							log.Printf("无法获取容器文件大小 (第%d次): %v", waitAttempt, err)
-							if waitAttempt < 6 {
-								time.Sleep(5 * time.Second)
-								continue
-							}
-						} else {
-							currentSize := strings.TrimSpace(sizeOut)
-							log.Printf("容器文件大小检查 #%d: %s bytes", waitAttempt, currentSize)
-
-							if waitAttempt == 1 {
-								expectedSize = currentSize
-							} else if currentSize == expectedSize {
-								log.Printf("文件大小已稳定: %s bytes", expectedSize)
-								break
-							} else {
-								log.Printf("文件大小仍在变化: %s -> %s", expectedSize, currentSize)
-								expectedSize = currentSize
-							}
-
-							if waitAttempt < 6 {
-								time.Sleep(5 * time.Second)
-							}
-						}
-					}
-
-					// 重试复制，最多3次
-					var copySuccess bool
-					for attempt := 1; attempt <= 3; attempt++ {
-						log.Printf("=== 复制尝试 #%d ===", attempt)
-						log.Printf("源文件: %s:%s", cfg.GenVideoContainer, inside)
-						log.Printf("目标文件: %s", hostOut)
-						log.Printf("期望大小: %s bytes", expectedSize)
-
-						copyCmd := fmt.Sprintf("docker cp %s:%s %s", cfg.GenVideoContainer, inside, hostOut)
-						log.Printf("执行命令: %s", copyCmd)
-
-						copyCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-						startTime := time.Now()
-						_, cpErr, err := run(copyCtx, "docker", "cp", fmt.Sprintf("%s:%s", cfg.GenVideoContainer, inside), hostOut)
-						copyDuration := time.Since(startTime)
-						cancel()
-
-						log.Printf("复制耗时: %v", copyDuration)
-
-						if err != nil {
-							log.Printf("第%d次复制失败: %v | %s", attempt, err, cpErr)
-							if attempt < 3 {
-								log.Printf("等待5秒后重试...")
-								time.Sleep(5 * time.Second)
-								continue
-							}
-						} else {
-							log.Printf("复制命令执行成功，开始验证文件...")
-
-							// 验证文件大小 - 添加更详细的检查
-							if expectedSize != "" {
-								// 先检查容器中的文件大小是否还在变化
-								checkCmd := fmt.Sprintf("docker exec -i %s stat -c %%s %s", cfg.GenVideoContainer, inside)
-								log.Printf("复制后检查容器文件大小: %s", checkCmd)
-
-								checkCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-								checkOut, _, checkErr := run(checkCtx, "docker", "exec", "-i", cfg.GenVideoContainer, "stat", "-c", "%s", inside)
-								cancel()
-
-								if checkErr == nil {
-									currentContainerSize := strings.TrimSpace(checkOut)
-									log.Printf("复制后容器文件大小: %s bytes (复制前: %s bytes)", currentContainerSize, expectedSize)
-
-									if currentContainerSize != expectedSize {
-										log.Printf("⚠️ 容器文件大小在复制过程中发生了变化!")
-										log.Printf("这可能是视频合成还在进行中，或者有并发写入")
-									}
-								}
-
-								// 检查目标文件
-								if stat, err := os.Stat(hostOut); err == nil {
-									actualSize := fmt.Sprintf("%d", stat.Size())
-									log.Printf("文件大小验证: 期望 %s bytes, 实际 %s bytes", expectedSize, actualSize)
-
-									// 计算差异
-									expectedInt, _ := strconv.ParseInt(expectedSize, 10, 64)
-									actualInt, _ := strconv.ParseInt(actualSize, 10, 64)
-									diff := actualInt - expectedInt
-									log.Printf("大小差异: %d bytes (%.2f MB)", diff, float64(diff)/1024/1024)
-
-									if actualSize == expectedSize {
-										log.Printf("✅ 文件复制成功，大小完全匹配!")
-										copySuccess = true
-										break
-									} else {
-										log.Printf("❌ 文件大小不匹配，需要重试")
-										if attempt < 3 {
-											log.Printf("等待5秒后重试...")
-											time.Sleep(5 * time.Second)
-											continue
-										}
-									}
-								} else {
-									log.Printf("❌ 无法读取目标文件: %v", err)
-									if attempt < 3 {
-										time.Sleep(5 * time.Second)
-										continue
-									}
-								}
-							} else {
-								// 无法验证大小，假设成功
-								log.Printf("⚠️ 无法验证文件大小，假设复制成功")
-								copySuccess = true
-								break
-							}
-						}
-					}
-
-					if !copySuccess {
-						status.Status = "failed"
-						status.Error = "视频拷贝到结果目录失败，已重试3次"
-						return
-					}
-
-					// 可选拷贝到Windows目录 - 直接从容器复制，避免使用被截断的文件
-					if req.CopyToCompany {
-						companyOut := filepath.Join(cfg.WindowsCompanyDir, resultFilename)
-						companyCmd := fmt.Sprintf("docker cp %s:%s %s", cfg.GenVideoContainer, inside, companyOut)
-						log.Printf("执行Windows拷贝命令: %s", companyCmd)
-
-						companyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-						startTime := time.Now()
-						_, cpErr, err := run(companyCtx, "docker", "cp", fmt.Sprintf("%s:%s", cfg.GenVideoContainer, inside), companyOut)
-						copyDuration := time.Since(startTime)
-						cancel()
-
-						log.Printf("Windows拷贝耗时: %v", copyDuration)
-						if err != nil {
-							log.Printf("拷贝到Windows目录失败: %v | %s", err, cpErr)
-						} else {
-							log.Printf("成功拷贝到Windows目录: %s", companyOut)
-						}
-					}
-
-					// 完成
-					status.Status = "completed"
-					status.CurrentStep = "处理完成"
-					status.Progress = 100
-					status.ResultVideo = resultFilename
-					status.ResultPath = hostOut
-					status.EndTime = time.Now().Unix()
-					status.TotalDuration = status.EndTime - status.StartTime
-					log.Printf("任务 %s 完成，总耗时: %d 秒 (%.1f 分钟)", taskID, status.TotalDuration, float64(status.TotalDuration)/60)
-					return
-				} else {
-					log.Printf("文件大小未稳定，继续等待...")
-				}
-			}
-
-			// 更新进度
-			if status.Progress < 90 {
-				status.Progress += 2
-				persistTaskStatus(status)
-			}
+	resp["template"] = item
+	c.JSON(200, resp)
+}
 
-		case <-timeout:
-			status.Status = "failed"
-			status.Progress = 100
-			status.CurrentStep = "视频合成超时"
-			status.Error = "视频合成超时"
-			status.EndTime = time.Now().Unix()
-			status.TotalDuration = status.EndTime - status.StartTime
-			log.Printf("任务 %s 超时失败，总耗时: %d 秒 (%.1f 分钟)，超时时长: %.1f 分钟", taskID, status.TotalDuration, float64(status.TotalDuration)/60, maxWait.Minutes())
-			return
-		}
+func parseBool(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
 	}
 }
 
@@ -1730,6 +1355,7 @@ func handleAutoRetry(c *gin.Context) {
 		AudioPath: status.AudioPath,
 		VideoPath: status.VideoPath,
 		Req:       *status.Request,
+		RequestID: requestIDFrom(c.Request.Context()),
 	}
 
 	status.RetryCount++
@@ -1742,18 +1368,32 @@ func handleAutoRetry(c *gin.Context) {
 	status.Error = ""
 	status.ResultVideo = ""
 	status.ResultPath = ""
+	status.SubtitleFiles = nil
+	status.SubtitlesBurned = false
+	status.SubtitleError = ""
+	status.SpeechTimeline = nil
+	status.Composed = false
+	status.Renditions = nil
+	status.DrivingAudioSeconds = 0
+	status.Verification = nil
+	status.ComposedVerification = nil
 	status.Username = loginUser
+	status.Checkpoint = ""
+	status.SubmittedAt = 0
+	status.RenderCode = ""
+	status.beginStage(stageQueueWait)
 
 	taskStatusMu.Lock()
 	taskStatusMap[taskID] = status
 	taskStatusMu.Unlock()
 
 	addTaskToIndex(taskID, status.StartTime)
-	persistTaskStatus(status)
 
-	if err := publishTask(payload); err != nil {
+	if err := enqueueTask(status, payload); err != nil {
 		status.Status = "failed"
 		status.Error = fmt.Sprintf("任务重新入队失败: %v", err)
+		status.endStage(stageOutcomeFailed, status.Error)
+		observeTaskOutcome(status)
 		status.EndTime = time.Now().Unix()
 		status.TotalDuration = status.EndTime - status.StartTime
 		persistTaskStatus(status)
@@ -1810,6 +1450,12 @@ func handleAutoArchive(c *gin.Context) {
 			if _, err := os.Stat(st.ResultPath); err == nil {
 				files = append(files, st.ResultPath)
 			}
+			for _, name := range resultExtras(st) {
+				p := filepath.Join(cfg.HostResultDir, name)
+				if _, err := os.Stat(p); err == nil {
+					files = append(files, p)
+				}
+			}
 		}
 	}
 	if len(files) == 0 {
@@ -1832,7 +1478,9 @@ func handleAutoArchive(c *gin.Context) {
 			f.Close()
 			continue
 		}
-		if _, err := io.Copy(w, f); err != nil {
+		n, err := io.Copy(w, f)
+		addBytesTransferred("archive", n)
+		if err != nil {
 			f.Close()
 			continue
 		}
@@ -1860,10 +1508,27 @@ func handleDownloadVideo(c *gin.Context) {
 		return
 	}
 
-	// 设置下载头
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Header("Content-Type", "video/mp4")
+	// 设置下载头：结果文件名带任务 ID 前缀，下载时以任务名命名
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", taskFilePrefixPattern.ReplaceAllString(filename, "")))
+	// 结果目录中还有字幕文件与派生输出
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".srt":
+		c.Header("Content-Type", "application/x-subrip; charset=utf-8")
+	case ".vtt":
+		c.Header("Content-Type", "text/vtt; charset=utf-8")
+	case ".mp3":
+		c.Header("Content-Type", "audio/mpeg")
+	case ".gif":
+		c.Header("Content-Type", "image/gif")
+	case ".webp":
+		c.Header("Content-Type", "image/webp")
+	case ".jpg":
+		c.Header("Content-Type", "image/jpeg")
+	default:
+		c.Header("Content-Type", "video/mp4")
+	}
 
 	// 发送文件
 	c.File(filePath)
+	addBytesTransferred("download", int64(c.Writer.Size()))
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "heygem"
+
+var (
+	metricStageInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stage_inflight",
+		Help:      "当前正在执行的任务数（按阶段）",
+	}, []string{"stage"})
+
+	metricStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stage_duration_seconds",
+		Help:      "流水线各阶段耗时",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 1800},
+	}, []string{"stage", "outcome"})
+
+	metricTaskOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_outcomes_total",
+		Help:      "任务结束状态计数，reason 为失败所在阶段",
+	}, []string{"status", "reason"})
+
+	metricUpstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "上游 TTS/视频服务 HTTP 请求耗时（至响应头）",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"upstream", "endpoint"})
+
+	metricUpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_errors_total",
+		Help:      "上游 TTS/视频服务错误计数，kind 为 transport|http_4xx|http_5xx",
+	}, []string{"upstream", "endpoint", "kind"})
+
+	metricFFmpegDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ffmpeg_duration_seconds",
+		Help:      "ffmpeg/ffprobe 调用耗时",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"binary", "result"})
+
+	metricBytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bytes_transferred_total",
+		Help:      "文件拷贝/下载字节数，kind 为 copy|docker_cp|tts_audio|proxy|archive|download",
+	}, []string{"kind"})
+
+	metricVideoBackendUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "video_backend_up",
+		Help:      "视频后端最近一次探测是否可达",
+	}, []string{"backend"})
+
+	metricVideoBackendLoad = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "video_backend_load",
+		Help:      "视频后端上正在渲染的任务数（调度时采样）",
+	}, []string{"backend"})
+
+	metricTTSBackendUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tts_backend_up",
+		Help:      "TTS 实例当前是否健康（探测或请求结果）",
+	}, []string{"backend"})
+
+	metricTTSBackendInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tts_backend_inflight",
+		Help:      "本进程发往各 TTS 实例的并发请求数",
+	}, []string{"backend"})
+
+	metricTTSBackendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tts_backend_requests_total",
+		Help:      "各 TTS 实例请求结果，result 为 ok|failover|error",
+	}, []string{"backend", "result"})
+
+	metricCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "circuit_state",
+		Help:      "上游熔断器状态：0 关闭，1 半开，2 熔断",
+	}, []string{"upstream", "target"})
+
+	metricMediaCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "media_cache_lookups_total",
+		Help:      "媒体缓存查询次数，kind 为 norm_audio|silent_video|tts，result 为 hit|miss",
+	}, []string{"kind", "result"})
+
+	metricMediaCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "media_cache_bytes",
+		Help:      "媒体缓存占用字节数（最近一次淘汰扫描）",
+	})
+
+	metricQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "RabbitMQ 任务队列中待消费的消息数",
+	}, rabbitQueueDepth)
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricStageInflight,
+		metricStageDuration,
+		metricTaskOutcomes,
+		metricUpstreamLatency,
+		metricUpstreamErrors,
+		metricFFmpegDuration,
+		metricBytesTransferred,
+		metricVideoBackendUp,
+		metricVideoBackendLoad,
+		metricTTSBackendUp,
+		metricTTSBackendInflight,
+		metricTTSBackendRequests,
+		metricCircuitState,
+		metricMediaCache,
+		metricMediaCacheBytes,
+		metricQueueDepth,
+	)
+}
+
+// 本进程内正在执行的阶段（taskID -> stage），避免跨进程/重启后 inc/dec 不成对
+var inflightStages sync.Map
+
+func trackStageBegin(taskID, stage string) {
+	trackStageEnd(taskID)
+	// 排队等待阶段在 API 进程开始、在 worker 结束，不计入 inflight
+	if stage == stageQueueWait {
+		return
+	}
+	inflightStages.Store(taskID, stage)
+	metricStageInflight.WithLabelValues(stage).Inc()
+}
+
+func trackStageEnd(taskID string) {
+	if v, ok := inflightStages.LoadAndDelete(taskID); ok {
+		metricStageInflight.WithLabelValues(v.(string)).Dec()
+	}
+}
+
+func observeStage(rec *StageRecord) {
+	metricStageDuration.WithLabelValues(rec.Name, rec.Outcome).Observe(float64(rec.DurationMs) / 1000)
+}
+
+func observeTaskOutcome(status *AutoProcessStatus) {
+	reason := "none"
+	if status.Status == "failed" {
+		reason = "unknown"
+		for i := len(status.Stages) - 1; i >= 0; i-- {
+			if status.Stages[i].Outcome == stageOutcomeFailed {
+				reason = status.Stages[i].Name
+				break
+			}
+		}
+	}
+	metricTaskOutcomes.WithLabelValues(status.Status, reason).Inc()
+}
+
+// upstreamLabels 根据请求地址识别上游（tts|video|other）与接口路径
+func upstreamLabels(rawURL string) (string, string) {
+	upstream := "other"
+	for _, b := range cfg.TTSBackends {
+		if b.BaseURL != "" && strings.HasPrefix(rawURL, b.BaseURL) {
+			upstream = "tts"
+			break
+		}
+	}
+	for _, b := range cfg.VideoBackends {
+		if upstream == "other" && b.BaseURL != "" && strings.HasPrefix(rawURL, b.BaseURL) {
+			upstream = "video"
+			break
+		}
+	}
+	endpoint := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		endpoint = u.Path
+	}
+	return upstream, endpoint
+}
+
+func observeUpstream(rawURL string, started time.Time, statusCode int, err error) {
+	upstream, endpoint := upstreamLabels(rawURL)
+	metricUpstreamLatency.WithLabelValues(upstream, endpoint).Observe(time.Since(started).Seconds())
+	switch {
+	case err != nil:
+		metricUpstreamErrors.WithLabelValues(upstream, endpoint, "transport").Inc()
+	case statusCode >= 500:
+		metricUpstreamErrors.WithLabelValues(upstream, endpoint, "http_5xx").Inc()
+	case statusCode >= 400:
+		metricUpstreamErrors.WithLabelValues(upstream, endpoint, "http_4xx").Inc()
+	}
+}
+
+func observeCommand(name string, started time.Time, err error) {
+	if name != "ffmpeg" && name != "ffprobe" {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metricFFmpegDuration.WithLabelValues(name, result).Observe(time.Since(started).Seconds())
+}
+
+func addBytesTransferred(kind string, n int64) {
+	if n > 0 {
+		metricBytesTransferred.WithLabelValues(kind).Add(float64(n))
+	}
+}
+
+// rabbitQueueDepth 使用独立 channel 被动声明队列读取消息数，失败时返回 NaN
+func rabbitQueueDepth() float64 {
+	conn, _, _ := currentRabbitChannels()
+	if conn == nil || conn.IsClosed() || rabbitQueueName == "" {
+		return math.NaN()
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		slog.Warn("读取队列深度失败", "err", err)
+		return math.NaN()
+	}
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(rabbitQueueName, true, false, false, false, nil)
+	if err != nil {
+		slog.Warn("读取队列深度失败", "err", err)
+		return math.NaN()
+	}
+	return float64(q.Messages)
+}
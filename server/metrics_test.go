@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpstreamLabels(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.TTSBackends = []TTSBackend{{Name: "tts1", BaseURL: "http://tts:8080"}}
+	cfg.VideoBackends = []VideoBackend{{Name: "gpu1", BaseURL: "http://gpu1:8383"}, {Name: "empty"}}
+
+	tests := []struct {
+		rawURL   string
+		upstream string
+		endpoint string
+	}{
+		{"http://tts:8080/v1/invoke", "tts", "/v1/invoke"},
+		{"http://gpu1:8383/easy/submit?x=1", "video", "/easy/submit"},
+		{"http://other:9000/ping", "other", "/ping"},
+		{"::bad", "other", "::bad"},
+	}
+	for _, tt := range tests {
+		upstream, endpoint := upstreamLabels(tt.rawURL)
+		if upstream != tt.upstream || endpoint != tt.endpoint {
+			t.Errorf("upstreamLabels(%q) = %q, %q，期望 %q, %q", tt.rawURL, upstream, endpoint, tt.upstream, tt.endpoint)
+		}
+	}
+}
+
+func TestObserveUpstreamErrorKinds(t *testing.T) {
+	const endpoint = "/metrics-test"
+	rawURL := "http://metrics.test" + endpoint
+	tests := []struct {
+		status int
+		err    error
+		kind   string
+	}{
+		{status: 0, err: errors.New("connection refused"), kind: "transport"},
+		{status: 503, kind: "http_5xx"},
+		{status: 404, kind: "http_4xx"},
+		{status: 200},
+	}
+	for _, tt := range tests {
+		before := map[string]float64{}
+		for _, kind := range []string{"transport", "http_5xx", "http_4xx"} {
+			before[kind] = testutil.ToFloat64(metricUpstreamErrors.WithLabelValues("other", endpoint, kind))
+		}
+		observeUpstream(rawURL, time.Now(), tt.status, tt.err)
+		for kind, v := range before {
+			want := v
+			if kind == tt.kind {
+				want++
+			}
+			if got := testutil.ToFloat64(metricUpstreamErrors.WithLabelValues("other", endpoint, kind)); got != want {
+				t.Errorf("status=%d err=%v: %s 计数 %v，期望 %v", tt.status, tt.err, kind, got, want)
+			}
+		}
+	}
+}
+
+func TestTrackStageInflight(t *testing.T) {
+	gauge := func(stage string) float64 {
+		return testutil.ToFloat64(metricStageInflight.WithLabelValues(stage))
+	}
+	tts, render := gauge(stageTTSInvoke), gauge(stageVideoRender)
+
+	trackStageBegin("metrics-task", stageQueueWait)
+	if got := gauge(stageQueueWait); got != 0 {
+		t.Errorf("排队阶段不计入 inflight，得到 %v", got)
+	}
+	trackStageBegin("metrics-task", stageTTSInvoke)
+	if got := gauge(stageTTSInvoke); got != tts+1 {
+		t.Errorf("tts_invoke inflight = %v，期望 %v", got, tts+1)
+	}
+	// 进入下一阶段时自动结束上一阶段
+	trackStageBegin("metrics-task", stageVideoRender)
+	if gauge(stageTTSInvoke) != tts || gauge(stageVideoRender) != render+1 {
+		t.Errorf("切换阶段后 inflight = %v/%v，期望 %v/%v", gauge(stageTTSInvoke), gauge(stageVideoRender), tts, render+1)
+	}
+	trackStageEnd("metrics-task")
+	trackStageEnd("metrics-task")
+	if got := gauge(stageVideoRender); got != render {
+		t.Errorf("结束后 video_render inflight = %v，期望 %v（重复结束不应重复扣减）", got, render)
+	}
+}
+
+func TestObserveTaskOutcomeReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		status AutoProcessStatus
+		reason string
+	}{
+		{name: "成功", status: AutoProcessStatus{Status: "completed"}, reason: "none"},
+		{name: "取最后一个失败阶段", status: AutoProcessStatus{Status: "failed", Stages: []StageRecord{
+			{Name: stageTTSInvoke, Outcome: stageOutcomeFailed},
+			{Name: stageTTSInvoke, Outcome: stageOutcomeOK},
+			{Name: stageVideoSubmit, Outcome: stageOutcomeFailed},
+		}}, reason: stageVideoSubmit},
+		{name: "无失败阶段", status: AutoProcessStatus{Status: "failed"}, reason: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metricTaskOutcomes.WithLabelValues(tt.status.Status, tt.reason)
+			before := testutil.ToFloat64(counter)
+			observeTaskOutcome(&tt.status)
+			if got := testutil.ToFloat64(counter); got != before+1 {
+				t.Errorf("%s/%s 计数 %v，期望 %v", tt.status.Status, tt.reason, got, before+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,999 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 异步自动化处理函数
+// ctx 由 worker 传入，仅在停机超时时取消；取消后任务以 queued 状态交回队列，
+// 已提交渲染的任务重新执行时直接从等待渲染结果处恢复。
+func processAutomatically(ctx context.Context, taskID string, audioPath, videoPath string, req AutoProcessReq) {
+	status := getOrCreateTaskStatus(taskID)
+	if req.TaskName == "" {
+		req.TaskName = fmt.Sprintf("task-%s", taskID)
+	}
+	if status.TaskName == "" {
+		status.TaskName = req.TaskName
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("处理异常: %v", r)
+			status.Progress = 0
+		}
+		if ctx.Err() != nil && status.Status != "completed" {
+			handOverTask(ctx, status)
+			persistTaskStatus(status)
+			return
+		}
+		// 视频后端满载时任务保持排队，排队阶段继续计时
+		if status.Status == "queued" {
+			persistTaskStatus(status)
+			return
+		}
+		// 确保失败场景记录结束时间与耗时
+		if status.Status == "failed" && status.EndTime == 0 {
+			status.EndTime = time.Now().Unix()
+			status.TotalDuration = status.EndTime - status.StartTime
+		}
+		// 收尾当前阶段
+		if status.Status == "failed" {
+			status.endStage(stageOutcomeFailed, status.Error)
+		} else {
+			status.endStage(stageOutcomeOK, "")
+		}
+		releaseVideoBackend(status.TaskID, status.VideoBackend)
+		removeTaskFiles(ctx, status)
+		observeTaskOutcome(status)
+		persistTaskStatus(status)
+	}()
+
+	if status.Checkpoint == checkpointSubmitted {
+		taskLog(ctx, status).Info("渲染任务已提交，恢复等待结果", "submitted_at", status.SubmittedAt)
+		// 接手交回或被回收的任务：渲染仍占用原后端，负载记到本次执行名下（集合操作幂等）
+		acquireVideoBackend(status.TaskID, status.VideoBackend)
+	} else {
+		if !prepareAndSubmit(ctx, status, audioPath, videoPath, &req) {
+			return
+		}
+		status.Checkpoint = checkpointSubmitted
+		status.SubmittedAt = time.Now().Unix()
+		persistTaskStatus(status)
+	}
+	awaitRenderResult(ctx, status, req)
+}
+
+// taskFileName 中间文件与交给后端的文件所在目录由各任务共享，文件名带上任务 ID 避免并发任务互相覆盖
+func taskFileName(taskID, name string) string {
+	return taskID + "_" + name
+}
+
+// taskFilePrefixPattern 匹配 taskFileName 加上的自动任务 ID 前缀
+var taskFilePrefixPattern = regexp.MustCompile(`^auto-\d+-\d+_`)
+
+// removeTaskFiles 任务结束后删除以任务 ID 命名的中间文件；交回或排队的任务不调用，恢复时仍需这些文件。
+// 上传的素材在任务完成后才删除，失败的任务重试时仍需使用
+func removeTaskFiles(ctx context.Context, status *AutoProcessStatus) {
+	dirs := []string{filepath.Join(cfg.WorkDir, "audio"), filepath.Join(cfg.WorkDir, "video"), cfg.HostVoiceDir}
+	if status.Status == "completed" {
+		dirs = append(dirs, filepath.Join(cfg.WorkDir, "upload"))
+	}
+	if status.VideoBackend != "" {
+		dirs = append(dirs, videoBackendForTask(status).HostVideoDir)
+	}
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, taskFileName(status.TaskID, "*")))
+		for _, m := range matches {
+			if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+				taskLog(ctx, status).Warn("清理任务文件失败", "path", m, "err", err)
+			}
+		}
+	}
+}
+
+// prepareAndSubmit 执行音视频预处理、TTS 与渲染提交，失败时设置 status 并返回 false
+func prepareAndSubmit(ctx context.Context, status *AutoProcessStatus, audioPath, videoPath string, req *AutoProcessReq) bool {
+	// 选择视频后端：素材需拷贝到该后端挂载的目录，结果也只能从该后端拉取
+	backend, pickErr := pickVideoBackend(ctx, status.VideoBackend)
+	if errors.Is(pickErr, errVideoBackendsBusy) {
+		status.Status = "queued"
+		status.WorkerID = ""
+		status.CurrentStep = "等待视频合成后端空闲"
+		taskLog(ctx, status).Info("暂无可用视频后端，任务退回队列")
+		return false
+	}
+	if pickErr != nil {
+		status.Status = "failed"
+		status.Error = pickErr.Error()
+		return false
+	}
+	if status.VideoBackend != "" && status.VideoBackend != backend.Name {
+		releaseVideoBackend(status.TaskID, status.VideoBackend)
+	}
+	status.VideoBackend = backend.Name
+	acquireVideoBackend(status.TaskID, backend.Name)
+	taskLog(ctx, status).Info("已选择视频后端", "backend", backend.Name)
+
+	// 步骤1: 处理音频 (10%)
+	status.CurrentStep = "处理音频文件"
+	status.Progress = 10
+	status.beginStage(stageAudioPrepare)
+	persistTaskStatus(status)
+
+	// 转换音频格式: MP3/其他格式 -> WAV (16kHz单声道)，按处理链预处理
+	work := filepath.Join(cfg.WorkDir, "audio")
+	os.MkdirAll(work, 0o755)
+	norm := filepath.Join(work, taskFileName(status.TaskID, "ref_norm.wav"))
+
+	var (
+		hit bool
+		err error
+	)
+	chain, chainErr := parseAudioChain(req.AudioFilters)
+	if chainErr != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("音频处理链无效: %v", chainErr)
+		return false
+	}
+	status.AudioChain = chain.filter()
+	if req.AudioPrepared {
+		// 模版上传时已按同一处理链生成规范化音频，直接使用
+		norm = audioPath
+		status.noteStage("使用模版预处理产物")
+	} else {
+		// 按处理链转换为 16kHz 单声道；相同输入与处理链直接取缓存
+		params := []string{"ar=16000", "ac=1", "c:a=pcm_s16le"}
+		if status.AudioChain != "" {
+			params = append(params, "af="+status.AudioChain)
+		}
+		hit, err = cachedTransform(cacheKindNormAudio, ".wav", audioPath, norm, params, func() error {
+			return convertReferenceAudio(ctx, audioPath, norm, chain)
+		})
+	}
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("音频格式转换失败: %v", err)
+		return false
+	}
+	if hit {
+		status.markCacheHit()
+	}
+
+	// 拷贝到 voice/data 目录
+	dst := filepath.Join(cfg.HostVoiceDir, taskFileName(status.TaskID, "ref_norm.wav"))
+	if err := copyFile(norm, dst); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("音频拷贝失败: %v", err)
+		return false
+	}
+	// 同步拷贝到视频目录，便于“自带音频”链路直接使用
+	dstInVideo := filepath.Join(backend.HostVideoDir, taskFileName(status.TaskID, "ref_norm.wav"))
+	if err := copyFile(norm, dstInVideo); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("音频拷贝到视频目录失败: %v", err)
+		return false
+	}
+
+	// 步骤2: 处理视频 (20%)
+	status.CurrentStep = "处理视频文件"
+	status.Progress = 20
+	status.beginStage(stageVideoPrepare)
+	persistTaskStatus(status)
+
+	// 视频静音处理
+	silentPath := filepath.Join(cfg.WorkDir, "video", taskFileName(status.TaskID, "silent.mp4"))
+	os.MkdirAll(filepath.Dir(silentPath), 0o755)
+	hit = false
+	prep := VideoPrep{}
+	if req.VideoPrep != nil {
+		prep = *req.VideoPrep
+	}
+	// 非 H.264/yuv420p 的素材渲染服务无法读取，需要转码
+	needEncode := prep.transforms()
+	if !needEncode {
+		if probe, err := probeMedia(ctx, videoPath); err == nil && !renderCompatible(probe.stream("video")) {
+			needEncode = true
+			taskLog(ctx, status).Info("视频编码与渲染服务不兼容，转码为 H.264", "task_id", status.TaskID)
+		}
+	}
+	switch {
+	case needEncode:
+		// 截取/裁剪/缩放/改帧率或转码；相同输入与选项直接取缓存
+		hit, err = cachedTransform(cacheKindSilentVideo, ".mp4", videoPath, silentPath, []string{"an", "x264", prep.signature()}, func() error {
+			return prepareAvatarVideo(ctx, videoPath, silentPath, prep)
+		})
+	case req.VideoPrepared:
+		// 模版上传时已生成静音视频，直接使用
+		silentPath = videoPath
+		status.noteStage("使用模版预处理产物")
+	default:
+		hit, err = cachedTransform(cacheKindSilentVideo, ".mp4", videoPath, silentPath, []string{"an", "c:v=copy"}, func() error {
+			_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", videoPath, "-an", "-c:v", "copy", silentPath)
+			if err != nil {
+				return fmt.Errorf("%v | %s", err, stderr)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("视频静音失败: %v", err)
+		return false
+	}
+	if hit {
+		status.markCacheHit()
+	}
+
+	// 拷贝到 face2face 目录
+	dstVideo := filepath.Join(backend.HostVideoDir, taskFileName(status.TaskID, "silent.mp4"))
+	if err := copyFile(silentPath, dstVideo); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("视频拷贝失败: %v", err)
+		return false
+	}
+
+	// 如果使用自带音频，跳过 TTS 流程（稍后直接用规整后的参考音频作为合成音频）
+	// 否则执行 TTS 预处理 + 合成
+	audioForVideo := taskFileName(status.TaskID, "ref_norm.wav") // 默认自带音频文件名
+	if req.UseTTS {
+		if req.Speaker == "" {
+			req.Speaker = "demo001"
+		}
+		outVoice := filepath.Join(cfg.HostVoiceDir, taskFileName(status.TaskID, sanitizeFilename(req.Speaker)+".wav"))
+		// 相同参考音频 + 发音人 + 文本 + 参数的合成结果可直接复用，跳过预处理与合成
+		ttsKey := ""
+		if digest, err := fileDigest(norm); err == nil {
+			params, _ := json.Marshal(ttsInvokeParams(req))
+			ttsKey = mediaCacheKey(cacheKindTTS, digest, string(params))
+		}
+		timelinePath := filepath.Join(cfg.WorkDir, "audio", taskFileName(status.TaskID, "timeline.json"))
+		if ttsKey != "" && fetchCached(cacheKindTTS, ttsKey, ".wav", outVoice) {
+			status.CurrentStep = "TTS语音合成（命中缓存）"
+			status.Progress = 50
+			status.beginStage(stageTTSInvoke)
+			status.markCacheHit()
+			// 时间戳与音频分开缓存，缺失时字幕退回启发式对齐
+			status.SpeechTimeline = nil
+			if fetchCached(cacheKindTTS, ttsKey, ".timeline.json", timelinePath) {
+				if data, err := os.ReadFile(timelinePath); err == nil {
+					json.Unmarshal(data, &status.SpeechTimeline)
+				}
+			}
+			persistTaskStatus(status)
+		} else {
+			if !synthesizeSpeech(ctx, status, req, outVoice) {
+				return false
+			}
+			if ttsKey != "" {
+				storeCached(cacheKindTTS, ttsKey, ".wav", outVoice)
+				if len(status.SpeechTimeline) > 0 {
+					data, _ := json.Marshal(status.SpeechTimeline)
+					if err := os.WriteFile(timelinePath, data, 0o644); err == nil {
+						storeCached(cacheKindTTS, ttsKey, ".timeline.json", timelinePath)
+					}
+				}
+			}
+		}
+
+		// 复制到视频目录
+		outInVideo := filepath.Join(backend.HostVideoDir, filepath.Base(outVoice))
+		if err := copyFile(outVoice, outInVideo); err != nil {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("TTS音频拷贝失败: %v", err)
+			return false
+		}
+		// 设置将要用于视频合成的音频文件名（容器内路径拼接时只需要文件名）
+		audioForVideo = filepath.Base(outVoice)
+	}
+
+	// 形象视频短于驱动音频时按 fill 循环或正倒放补齐
+	if prep.Fill == videoFillLoop || prep.Fill == videoFillPingPong {
+		if !fitAvatarVideo(ctx, status, prep.Fill, dstVideo, filepath.Join(backend.HostVideoDir, audioForVideo)) {
+			return false
+		}
+	}
+
+	// 步骤5: 视频合成提交 (70%)
+	status.CurrentStep = "提交视频合成任务"
+	status.Progress = 70
+	status.beginStage(stageVideoSubmit)
+	persistTaskStatus(status)
+
+	if req.TaskName == "" {
+		req.TaskName = fmt.Sprintf("task-%s", status.TaskID)
+	}
+	status.TaskName = req.TaskName
+	// 任务名由用户填写可能重复，后端编号按任务 ID 与重试次数生成，避免取到其他任务或上次执行的结果
+	status.RenderCode = fmt.Sprintf("%s-%d", status.TaskID, status.RetryCount)
+	// 记录驱动音频时长，取回成片后据此校验；探测失败时跳过该项比较
+	if probe, err := probeMedia(ctx, filepath.Join(backend.HostVideoDir, audioForVideo)); err == nil {
+		status.DrivingAudioSeconds = round3(probe.duration())
+	} else {
+		taskLog(ctx, status).Warn("驱动音频时长探测失败", "err", err)
+	}
+	render := legacyRenderOptions
+	if req.Render != nil {
+		render = *req.Render
+	}
+	payload := map[string]any{
+		"audio_url":        filepath.Join(backend.DataRoot, audioForVideo),
+		"video_url":        filepath.Join(backend.DataRoot, filepath.Base(dstVideo)),
+		"code":             status.RenderCode,
+		"chaofen":          render.Chaofen,
+		"watermark_switch": render.WatermarkSwitch,
+		"pn":               render.PN,
+	}
+
+	body, _ := json.Marshal(payload)
+	url := fmt.Sprintf("%s/easy/submit", backend.BaseURL)
+	resp, err := httpJSON(ctx, http.MethodPost, url, body, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("视频合成提交失败: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	submitBody, _ := io.ReadAll(resp.Body)
+	status.noteStage(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, redactBody(submitBody)))
+	if resp.StatusCode != 200 {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("视频合成提交失败: %s", redactBody(submitBody))
+		return false
+	}
+
+	return true
+}
+
+// ttsInvokeParams 合成请求中除参考音频外的全部参数；序列化结果（键有序）同时作为 TTS 缓存键，
+// 参数变化时缓存自然失效。参考音频地址与文本由预处理返回，以参考音频内容摘要代替
+func ttsInvokeParams(req *AutoProcessReq) map[string]any {
+	return map[string]any{
+		"speaker":            req.Speaker,
+		"text":               req.Text,
+		"format":             "wav",
+		"topP":               0.7,
+		"max_new_tokens":     1024,
+		"chunk_length":       100,
+		"repetition_penalty": 1.2,
+		"temperature":        0.7,
+		"need_asr":           true,
+		"streaming":          false,
+		"is_fixed_seed":      0,
+		"is_norm":            0,
+	}
+}
+
+// fitAvatarVideo 比较视频与驱动音频时长，视频较短时原地替换为补齐后的视频，失败时设置 status 并返回 false
+func fitAvatarVideo(ctx context.Context, status *AutoProcessStatus, mode, videoPath, audioPath string) bool {
+	audioProbe, err := probeMedia(ctx, audioPath)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("读取驱动音频时长失败: %v", err)
+		return false
+	}
+	videoProbe, err := probeMedia(ctx, videoPath)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("读取形象视频时长失败: %v", err)
+		return false
+	}
+	audioSec, videoSec := audioProbe.duration(), videoProbe.duration()
+	if videoSec >= audioSec {
+		return true
+	}
+	status.CurrentStep = "补齐形象视频时长"
+	status.beginStage(stageVideoFit)
+	persistTaskStatus(status)
+	fitted := filepath.Join(cfg.WorkDir, "video", taskFileName(status.TaskID, "fitted.mp4"))
+	if err := fitVideoToAudio(ctx, videoPath, fitted, audioSec, mode); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("形象视频补齐失败: %v", err)
+		return false
+	}
+	if err := copyFile(fitted, videoPath); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("视频拷贝失败: %v", err)
+		return false
+	}
+	status.noteStage(fmt.Sprintf("%s: %.1fs -> %.1fs", mode, videoSec, audioSec))
+	return true
+}
+
+// synthesizeSpeech 执行 TTS 预处理与合成并把音频写入 outVoice，失败时设置 status 并返回 false
+func synthesizeSpeech(ctx context.Context, status *AutoProcessStatus, req *AutoProcessReq, outVoice string) bool {
+	// 步骤3: TTS预处理 (30%)
+	status.CurrentStep = "TTS预处理"
+	status.Progress = 30
+	status.beginStage(stageTTSPreprocess)
+	persistTaskStatus(status)
+
+	preprocessReq := PreprocessReq{
+		Format:         "wav",
+		ReferenceAudio: taskFileName(status.TaskID, "ref_norm.wav"),
+		Lang:           "zh",
+	}
+
+	body, _ := json.Marshal(preprocessReq)
+	resp, ttsBackend, err := ttsRequest(ctx, "/v1/preprocess_and_tran", body, status.TTSBackend)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS预处理失败: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	status.TTSBackend = ttsBackend
+
+	respBody, _ := io.ReadAll(resp.Body)
+	// 预处理响应含参考音频识别文本，写入状态前脱敏
+	status.noteStage(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, redactBody(respBody)))
+	if resp.StatusCode != 200 {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS预处理失败: %s", redactBody(respBody))
+		return false
+	}
+
+	var preResp PreprocessResp
+	if err := json.Unmarshal(respBody, &preResp); err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS预处理解析失败: %v", err)
+		return false
+	}
+	// 预处理可能以 HTTP 200 + code != 0 的方式返回失败，需要显式拦截
+	if preResp.Code != 0 || preResp.ASRFormatAudioURL == "" || preResp.ReferenceAudioText == "" {
+		status.Status = "failed"
+		// 将上游 msg 透出，便于定位（典型：asr failed）
+		status.Error = fmt.Sprintf("TTS预处理失败: code=%d, msg=%s", preResp.Code, preResp.Msg)
+		return false
+	}
+
+	taskLog(ctx, status).Info("TTS预处理响应", "reference_audio", preResp.ASRFormatAudioURL, "reference_text", preResp.ReferenceAudioText)
+
+	// 步骤4: TTS合成 (50%)
+	status.CurrentStep = "TTS语音合成"
+	status.Progress = 50
+	status.beginStage(stageTTSInvoke)
+	persistTaskStatus(status)
+
+	// 使用map构建请求，避免结构体问题；need_asr 开启，识别出的逐句时间戳用于字幕对齐
+	ttsReq := ttsInvokeParams(req)
+	ttsReq["reference_audio"] = preResp.ASRFormatAudioURL
+	ttsReq["reference_text"] = preResp.ReferenceAudioText
+
+	body, _ = json.Marshal(ttsReq)
+	taskLog(ctx, status).Info("提交TTS合成", "speaker", req.Speaker, "text", req.Text, "reference_audio", preResp.ASRFormatAudioURL)
+	// 优先沿用预处理所用实例
+	resp, ttsBackend, err = ttsRequest(ctx, "/v1/invoke", body, status.TTSBackend)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS合成失败: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	status.TTSBackend = ttsBackend
+
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		status.noteStage(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, redactBody(b)))
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS合成失败: %s", redactBody(b))
+		return false
+	}
+
+	// need_asr 时返回 JSON（base64 音频 + 逐句时间戳）；直接返回音频流的实例没有时间戳
+	var audio io.Reader = resp.Body
+	status.SpeechTimeline = nil
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		var result ttsASRResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("TTS合成响应解析失败: %v", err)
+			return false
+		}
+		if result.Code != 0 || result.Audio == "" {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("TTS合成失败: code=%d, msg=%s", result.Code, result.Msg)
+			return false
+		}
+		raw, err := base64.StdEncoding.DecodeString(result.Audio)
+		if err != nil {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("TTS音频解码失败: %v", err)
+			return false
+		}
+		audio = bytes.NewReader(raw)
+		status.SpeechTimeline = cleanSpeechTimeline(result.ASR)
+	}
+	if len(status.SpeechTimeline) == 0 {
+		taskLog(ctx, status).Info("TTS 未返回语音识别时间戳，字幕将按启发式对齐")
+	}
+
+	// 保存TTS生成的音频
+	f, err := os.Create(outVoice)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS音频保存失败: %v", err)
+		return false
+	}
+	written, err := io.Copy(f, audio)
+	addBytesTransferred("tts_audio", written)
+	if err != nil {
+		f.Close()
+		status.Status = "failed"
+		status.Error = fmt.Sprintf("TTS音频写入失败: %v", err)
+		return false
+	}
+	f.Close()
+	status.noteStage(fmt.Sprintf("HTTP %d: %s, %d bytes, asr %d 句", resp.StatusCode, resp.Header.Get("Content-Type"), written, len(status.SpeechTimeline)))
+	return true
+}
+
+// ttsASRResult need_asr 时 TTS 合成接口的 JSON 响应：base64 编码的音频与逐句时间戳（秒）
+type ttsASRResult struct {
+	Code  int             `json:"code"`
+	Msg   string          `json:"msg"`
+	Audio string          `json:"audio"`
+	ASR   []speechSegment `json:"asr"`
+}
+
+// cleanSpeechTimeline 丢弃空文本与起止时间非法的句子，并按起点排序
+func cleanSpeechTimeline(segments []speechSegment) []speechSegment {
+	var out []speechSegment
+	for _, s := range segments {
+		s.Text = strings.TrimSpace(s.Text)
+		if s.Text == "" || s.Start < 0 || s.End <= s.Start {
+			continue
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+// postProcessResult 成片拷贝到结果目录后依次生成字幕、合成背景音乐与片头片尾、生成输出规格；返回 false 表示任务失败
+func postProcessResult(ctx context.Context, status *AutoProcessStatus, req AutoProcessReq, videoPath string) bool {
+	var plan *composePlan
+	if req.Compose != nil {
+		var err error
+		if plan, err = planComposition(ctx, *req.Compose); err != nil {
+			status.Status = "failed"
+			status.Error = fmt.Sprintf("成片合成素材无效: %v", err)
+			return false
+		}
+	}
+	// 字幕先于合成：按正片人声对齐，烧录时不受背景音乐与片头影响
+	if req.Subtitles != nil {
+		generateSubtitles(ctx, status, req, videoPath, plan.introSeconds())
+	}
+	if plan != nil && !composeResult(ctx, status, plan, videoPath) {
+		return false
+	}
+	if len(req.Renditions) > 0 {
+		generateRenditions(ctx, status, req.Renditions, videoPath, plan.introSeconds())
+	}
+	return true
+}
+
+// resultExtras 结果目录中随成片输出的字幕文件与派生输出
+func resultExtras(status *AutoProcessStatus) []string {
+	names := slices.Clone(status.SubtitleFiles)
+	for _, r := range status.Renditions {
+		if r.Filename != "" {
+			names = append(names, r.Filename)
+		}
+	}
+	return names
+}
+
+// copyResultExtras 把字幕与派生输出随成片拷贝到 dir
+func copyResultExtras(ctx context.Context, status *AutoProcessStatus, dir string) {
+	for _, name := range resultExtras(status) {
+		if err := copyFile(filepath.Join(cfg.HostResultDir, name), filepath.Join(dir, name)); err != nil {
+			taskLog(ctx, status).Warn("拷贝结果文件失败", "file", name, "err", err)
+		}
+	}
+}
+
+// awaitRenderResult 轮询渲染结果并拷贝到结果目录
+func awaitRenderResult(ctx context.Context, status *AutoProcessStatus, req AutoProcessReq) {
+	taskCode := status.RenderCode
+	if taskCode == "" {
+		// 引入 RenderCode 之前提交的任务以任务名为编号
+		taskCode = status.TaskName
+	}
+	containerResultName := fmt.Sprintf("%s-r.mp4", taskCode)
+	// 结果文件带任务 ID 前缀避免同名任务互相覆盖，下载时去掉前缀
+	resultFilename := taskFileName(status.TaskID, status.TaskName+".mp4")
+	backend := videoBackendForTask(status)
+	containerResultPath := filepath.Join(backend.DataRoot, "temp", containerResultName)
+
+	// 步骤6: 轮询视频合成结果 (70-100%)
+	status.CurrentStep = "等待视频合成完成"
+	status.Progress = 80
+	status.beginStage(stageVideoRender)
+	persistTaskStatus(status)
+
+	// 开始轮询，间隔30秒
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	maxWait := cfg.VideoWaitTimeout
+	if maxWait <= 0 {
+		maxWait = 15 * time.Minute
+	}
+	// 恢复执行时从首次提交时间起算超时
+	remaining := maxWait
+	if status.SubmittedAt > 0 {
+		remaining -= time.Since(time.Unix(status.SubmittedAt, 0))
+	}
+	timeout := time.After(remaining)
+	checkCount := 0
+
+	for {
+		select {
+		case <-ticker.C:
+			checkCount++
+			// 检查视频是否生成完成
+			inside := containerResultPath
+
+			// 更新状态信息
+			status.CurrentStep = fmt.Sprintf("等待视频合成完成 (已检查 %d 次，约 %d 分钟，最多等待约 %.1f 分钟)", checkCount, checkCount/2, maxWait.Minutes())
+			persistTaskStatus(status)
+
+			// 优先通过宿主机挂载目录检测结果文件，避免依赖 docker 命令
+			resultName := containerResultName
+			srcOnHost := filepath.Join(backend.HostVideoDir, "temp", resultName)
+			hostOut := filepath.Join(cfg.HostResultDir, resultFilename)
+			if st, err := os.Stat(srcOnHost); backendHasCapability(backend.Name, capLocalResultDir) && err == nil && !st.IsDir() {
+				taskLog(ctx, status).Info("检测到结果文件", "path", srcOnHost, "size", st.Size())
+				// 等待大小稳定（连续3次相同，每次间隔3s）
+				last := st.Size()
+				stable := 1
+				for i := 2; i <= 3; i++ {
+					time.Sleep(3 * time.Second)
+					st2, e2 := os.Stat(srcOnHost)
+					if e2 != nil {
+						taskLog(ctx, status).Warn("稳定性检查失败", "err", e2)
+						break
+					}
+					cur := st2.Size()
+					taskLog(ctx, status).Debug("稳定性检查", "check", i, "size", cur)
+					if cur == last {
+						stable++
+					} else {
+						last = cur
+						stable = 1
+					}
+				}
+				if stable >= 3 {
+					status.beginStage(stageResultFetch)
+					persistTaskStatus(status)
+					// 复制并校验
+					copyOK := false
+					for attempt := 1; attempt <= 3; attempt++ {
+						taskLog(ctx, status).Info("复制结果文件(本地)", "attempt", attempt, "src", srcOnHost, "dst", hostOut)
+						if err := copyFile(srcOnHost, hostOut); err != nil {
+							taskLog(ctx, status).Warn("复制失败", "attempt", attempt, "err", err)
+							if attempt < 3 {
+								time.Sleep(5 * time.Second)
+								continue
+							}
+						} else {
+							if dstStat, e3 := os.Stat(hostOut); e3 == nil && dstStat.Size() == last {
+								taskLog(ctx, status).Info("文件复制成功，大小匹配", "size", last)
+								copyOK = true
+								break
+							}
+							taskLog(ctx, status).Warn("复制后大小不匹配或读取失败", "attempt", attempt)
+							if attempt < 3 {
+								time.Sleep(5 * time.Second)
+								continue
+							}
+						}
+					}
+					if !copyOK {
+						status.Status = "failed"
+						status.Error = "视频拷贝到结果目录失败，已重试3次"
+						return
+					}
+					if !verifyResult(ctx, status, hostOut) || !postProcessResult(ctx, status, req, hostOut) {
+						return
+					}
+					// 可选拷贝到公司目录
+					if req.CopyToCompany {
+						companyOut := filepath.Join(cfg.WindowsCompanyDir, resultFilename)
+						taskLog(ctx, status).Info("复制到公司目录", "dst", companyOut)
+						if err := copyFile(hostOut, companyOut); err != nil {
+							taskLog(ctx, status).Warn("拷贝到公司目录失败", "err", err)
+						}
+						copyResultExtras(ctx, status, cfg.WindowsCompanyDir)
+					}
+					// 完成
+					status.Status = "completed"
+					status.CurrentStep = "处理完成"
+					status.Progress = 100
+					status.ResultVideo = resultFilename
+					status.ResultPath = hostOut
+					status.EndTime = time.Now().Unix()
+					status.TotalDuration = status.EndTime - status.StartTime
+					taskLog(ctx, status).Info("任务完成", "total_seconds", status.TotalDuration)
+					return
+				} else {
+					taskLog(ctx, status).Info("文件大小未稳定，继续等待")
+				}
+			}
+
+			// 本机无法访问渲染容器时只依赖挂载目录
+			if !backendHasCapability(backend.Name, capDocker) {
+				continue
+			}
+
+			// 检查文件是否存在且写入完成
+			taskLog(ctx, status).Debug("检查容器内结果文件", "container", backend.Container, "path", inside)
+
+			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			stdout, stderr, err := run(checkCtx, "docker", "exec", "-i", backend.Container, "bash", "-lc", fmt.Sprintf("if [ -f '%s' ]; then echo FOUND; else echo MISSING; fi", inside))
+			cancel()
+
+			// 静默检查，只在出错时记录日志
+			if err != nil {
+				taskLog(ctx, status).Warn("视频合成检查出错", "check", checkCount, "path", inside, "err", err, "stdout", stdout, "stderr", stderr)
+			}
+
+			// 检查stdout和stderr中是否包含FOUND
+			if err == nil && (strings.Contains(stdout, "FOUND") || strings.Contains(stderr, "FOUND")) {
+				// 文件存在，但需要检查是否还在写入
+				taskLog(ctx, status).Info("文件已存在，检查是否还在写入")
+
+				// 等待文件稳定 - 检查文件大小是否还在变化
+				var lastSize string
+				var stableCount int
+
+				for stabilityCheck := 1; stabilityCheck <= 5; stabilityCheck++ {
+					sizeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+					sizeOut, _, sizeErr := run(sizeCtx, "docker", "exec", "-i", backend.Container, "stat", "-c", "%s", inside)
+					cancel()
+
+					if sizeErr != nil {
+						taskLog(ctx, status).Warn("稳定性检查失败", "err", sizeErr)
+						break
+					}
+
+					currentSize := strings.TrimSpace(sizeOut)
+					taskLog(ctx, status).Debug("稳定性检查", "check", stabilityCheck, "size", currentSize)
+
+					if stabilityCheck == 1 {
+						lastSize = currentSize
+						stableCount = 1
+					} else if currentSize == lastSize {
+						stableCount++
+						if stableCount >= 3 {
+							taskLog(ctx, status).Info("文件写入完成，大小稳定", "size", currentSize)
+							break
+						}
+					} else {
+						taskLog(ctx, status).Debug("文件大小仍在变化", "from", lastSize, "to", currentSize)
+						lastSize = currentSize
+						stableCount = 1
+					}
+
+					if stabilityCheck < 5 {
+						time.Sleep(3 * time.Second)
+					}
+				}
+
+				// 如果文件稳定了，继续处理
+				if stableCount >= 3 {
+					// 视频生成完成
+					status.CurrentStep = "下载最终视频"
+					status.Progress = 95
+					status.beginStage(stageResultFetch)
+					persistTaskStatus(status)
+
+					// 拷贝到结果目录 - 带重试和完整性检查
+					hostOut := filepath.Join(cfg.HostResultDir, resultFilename)
+					if err := os.MkdirAll(filepath.Dir(hostOut), 0o755); err != nil {
+						status.Status = "failed"
+						status.Error = fmt.Sprintf("创建结果目录失败: %v", err)
+						return
+					}
+
+					// 获取容器中文件的大小 - 等待文件稳定
+					taskLog(ctx, status).Info("开始检查容器文件", "path", inside)
+
+					// 等待文件大小稳定（最多等待30秒）
+					var expectedSize string
+					for waitAttempt := 1; waitAttempt <= 6; waitAttempt++ {
+						sizeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+						sizeOut, _, err := run(sizeCtx, "docker", "exec", "-i", backend.Container, "stat", "-c", "%s", inside)
+						cancel()
+						if err != nil {
+							taskLog(ctx, status).Warn("无法获取容器文件大小", "attempt", waitAttempt, "err", err)
+							if waitAttempt < 6 {
+								time.Sleep(5 * time.Second)
+								continue
+							}
+						} else {
+							currentSize := strings.TrimSpace(sizeOut)
+							taskLog(ctx, status).Debug("容器文件大小检查", "attempt", waitAttempt, "size", currentSize)
+
+							if waitAttempt == 1 {
+								expectedSize = currentSize
+							} else if currentSize == expectedSize {
+								taskLog(ctx, status).Info("文件大小已稳定", "size", expectedSize)
+								break
+							} else {
+								taskLog(ctx, status).Debug("文件大小仍在变化", "from", expectedSize, "to", currentSize)
+								expectedSize = currentSize
+							}
+
+							if waitAttempt < 6 {
+								time.Sleep(5 * time.Second)
+							}
+						}
+					}
+
+					// 重试复制，最多3次
+					var copySuccess bool
+					for attempt := 1; attempt <= 3; attempt++ {
+						taskLog(ctx, status).Info("复制结果文件(docker cp)", "attempt", attempt, "src", backend.Container+":"+inside, "dst", hostOut, "expected_size", expectedSize)
+
+						copyCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+						startTime := time.Now()
+						_, cpErr, err := run(copyCtx, "docker", "cp", fmt.Sprintf("%s:%s", backend.Container, inside), hostOut)
+						copyDuration := time.Since(startTime)
+						cancel()
+						if err == nil {
+							if st, e := os.Stat(hostOut); e == nil {
+								addBytesTransferred("docker_cp", st.Size())
+							}
+						}
+
+						taskLog(ctx, status).Info("docker cp 结束", "attempt", attempt, "duration_ms", copyDuration.Milliseconds())
+
+						if err != nil {
+							taskLog(ctx, status).Warn("复制失败，5 秒后重试", "attempt", attempt, "err", err, "stderr", cpErr)
+							if attempt < 3 {
+								time.Sleep(5 * time.Second)
+								continue
+							}
+						} else {
+
+							// 验证文件大小 - 添加更详细的检查
+							if expectedSize != "" {
+								// 先检查容器中的文件大小是否还在变化
+								checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+								checkOut, _, checkErr := run(checkCtx, "docker", "exec", "-i", backend.Container, "stat", "-c", "%s", inside)
+								cancel()
+
+								if checkErr == nil {
+									currentContainerSize := strings.TrimSpace(checkOut)
+									if currentContainerSize != expectedSize {
+										// 可能是视频合成还在进行中，或者有并发写入
+										taskLog(ctx, status).Warn("容器文件大小在复制过程中发生了变化", "before", expectedSize, "after", currentContainerSize)
+									}
+								}
+
+								// 检查目标文件
+								if stat, err := os.Stat(hostOut); err == nil {
+									actualSize := fmt.Sprintf("%d", stat.Size())
+									// 计算差异
+									expectedInt, _ := strconv.ParseInt(expectedSize, 10, 64)
+									actualInt, _ := strconv.ParseInt(actualSize, 10, 64)
+									taskLog(ctx, status).Info("文件大小验证", "expected", expectedSize, "actual", actualSize, "diff", actualInt-expectedInt)
+
+									if actualSize == expectedSize {
+										copySuccess = true
+										break
+									} else {
+										taskLog(ctx, status).Warn("文件大小不匹配，5 秒后重试", "attempt", attempt)
+										if attempt < 3 {
+											time.Sleep(5 * time.Second)
+											continue
+										}
+									}
+								} else {
+									taskLog(ctx, status).Warn("无法读取目标文件", "err", err)
+									if attempt < 3 {
+										time.Sleep(5 * time.Second)
+										continue
+									}
+								}
+							} else {
+								// 无法验证大小，假设成功
+								taskLog(ctx, status).Warn("无法验证文件大小，假设复制成功")
+								copySuccess = true
+								break
+							}
+						}
+					}
+
+					if !copySuccess {
+						status.Status = "failed"
+						status.Error = "视频拷贝到结果目录失败，已重试3次"
+						return
+					}
+					if !verifyResult(ctx, status, hostOut) || !postProcessResult(ctx, status, req, hostOut) {
+						return
+					}
+
+					// 烧录字幕或合成后的成片只存在于结果目录
+					if req.CopyToCompany && (status.SubtitlesBurned || status.Composed) {
+						companyOut := filepath.Join(cfg.WindowsCompanyDir, resultFilename)
+						if err := copyFile(hostOut, companyOut); err != nil {
+							taskLog(ctx, status).Warn("拷贝到Windows目录失败", "err", err)
+						}
+					} else if req.CopyToCompany {
+						// 可选拷贝到Windows目录 - 直接从容器复制，避免使用被截断的文件
+						companyOut := filepath.Join(cfg.WindowsCompanyDir, resultFilename)
+						companyCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+						startTime := time.Now()
+						_, cpErr, err := run(companyCtx, "docker", "cp", fmt.Sprintf("%s:%s", backend.Container, inside), companyOut)
+						copyDuration := time.Since(startTime)
+						cancel()
+
+						if err != nil {
+							taskLog(ctx, status).Warn("拷贝到Windows目录失败", "err", err, "stderr", cpErr)
+						} else {
+							taskLog(ctx, status).Info("成功拷贝到Windows目录", "dst", companyOut, "duration_ms", copyDuration.Milliseconds())
+							if st, e := os.Stat(companyOut); e == nil {
+								addBytesTransferred("docker_cp", st.Size())
+							}
+						}
+					}
+					if req.CopyToCompany {
+						copyResultExtras(ctx, status, cfg.WindowsCompanyDir)
+					}
+
+					// 完成
+					status.Status = "completed"
+					status.CurrentStep = "处理完成"
+					status.Progress = 100
+					status.ResultVideo = resultFilename
+					status.ResultPath = hostOut
+					status.EndTime = time.Now().Unix()
+					status.TotalDuration = status.EndTime - status.StartTime
+					taskLog(ctx, status).Info("任务完成", "total_seconds", status.TotalDuration)
+					return
+				} else {
+					taskLog(ctx, status).Info("文件大小未稳定，继续等待")
+				}
+			}
+
+			// 更新进度
+			if status.Progress < 90 {
+				status.Progress += 2
+				persistTaskStatus(status)
+			}
+
+		case <-ctx.Done():
+			// 停机超时，交由 processAutomatically 将任务交回队列
+			return
+
+		case <-timeout:
+			status.Status = "failed"
+			status.Progress = 100
+			status.CurrentStep = "视频合成超时"
+			status.Error = "视频合成超时"
+			status.EndTime = time.Now().Unix()
+			status.TotalDuration = status.EndTime - status.StartTime
+			taskLog(ctx, status).Error("视频合成超时", "total_seconds", status.TotalDuration, "timeout_minutes", maxWait.Minutes())
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// mediaProbe ffprobe -show_format -show_streams 的 JSON 输出（只保留用到的字段）
+type mediaProbe struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []probeStream `json:"streams"`
+}
+
+type probeStream struct {
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	SampleRate   string `json:"sample_rate"`
+	Channels     int    `json:"channels"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	PixFmt       string `json:"pix_fmt"`
+	Duration     string `json:"duration"`
+	StartTime    string `json:"start_time"`
+	BitRate      string `json:"bit_rate"`
+	Tags         struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+	SideDataList []struct {
+		Rotation int `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+func probeMedia(ctx context.Context, path string) (*mediaProbe, error) {
+	stdout, stderr, err := run(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe 失败: %v | %s", err, stderr)
+	}
+	var p mediaProbe
+	if err := json.Unmarshal([]byte(stdout), &p); err != nil {
+		return nil, fmt.Errorf("解析 ffprobe 输出失败: %w", err)
+	}
+	return &p, nil
+}
+
+// duration 容器时长（秒），缺失时为 0
+func (p *mediaProbe) duration() float64 {
+	d, _ := strconv.ParseFloat(p.Format.Duration, 64)
+	return d
+}
+
+// stream 返回第一个指定类型（audio/video）的流；音频文件内嵌的封面图不算视频流
+func (p *mediaProbe) stream(codecType string) *probeStream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == codecType && p.Streams[i].Disposition.AttachedPic == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// parseFrameRate 解析 ffprobe 的分数帧率（如 30000/1001）
+func parseFrameRate(raw string) float64 {
+	num, den, ok := strings.Cut(raw, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(raw, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*100) / 100
+}
+
+// templateMetaFromProbe 提取模版展示用的媒体信息；视频模版的 codec 为视频编码，音轨编码记在 audio_codec
+func templateMetaFromProbe(p *mediaProbe) *TemplateMeta {
+	meta := &TemplateMeta{
+		Duration: math.Round(p.duration()*1000) / 1000,
+		Format:   p.Format.FormatName,
+	}
+	meta.SizeBytes, _ = strconv.ParseInt(p.Format.Size, 10, 64)
+	meta.BitRate, _ = strconv.ParseInt(p.Format.BitRate, 10, 64)
+	if a := p.stream("audio"); a != nil {
+		meta.Codec = a.CodecName
+		meta.SampleRate, _ = strconv.Atoi(a.SampleRate)
+		meta.Channels = a.Channels
+	}
+	if v := p.stream("video"); v != nil {
+		meta.AudioCodec = meta.Codec
+		meta.Codec = v.CodecName
+		meta.Width = v.Width
+		meta.Height = v.Height
+		meta.FPS = parseFrameRate(v.AvgFrameRate)
+	}
+	return meta
+}
+
+// displaySize 按旋转元数据（手机竖拍常见）换算显示宽高
+func (s *probeStream) displaySize() (int, int) {
+	rotation, _ := strconv.Atoi(s.Tags.Rotate)
+	for _, sd := range s.SideDataList {
+		if sd.Rotation != 0 {
+			rotation = sd.Rotation
+		}
+	}
+	if rotation%180 != 0 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
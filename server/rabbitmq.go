@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	rabbitMu         sync.RWMutex
+	rabbitConn       *amqp.Connection
+	rabbitChannel    *amqp.Channel // 消费用，Qos=1
+	rabbitPubChannel *amqp.Channel // 发布用，开启 publisher confirms
+	rabbitQueueName  string
+	rabbitDegraded   atomic.Bool
+	rabbitClosing    atomic.Bool
+	// rabbitReconnected 在每次重连成功后被关闭并替换，用于唤醒等待方
+	rabbitReconnected = make(chan struct{})
+
+	// 发布 channel 上的确认需按顺序等待，串行化发布
+	rabbitPubMu sync.Mutex
+	// 同一时间只允许一个补发流程，避免重复投递
+	rabbitFlushMu sync.Mutex
+)
+
+const (
+	rabbitPublishAttempts = 3
+	rabbitReconnectMin    = time.Second
+	rabbitReconnectMax    = 30 * time.Second
+	// 定期补发缓冲任务，覆盖连接未断开期间缓冲的消息
+	rabbitFlushInterval = 30 * time.Second
+)
+
+// errPublishUnconfirmed broker 未在超时内确认：消息可能已经入队，立即重发会重复投递，
+// 由 watchUnconfirmed 等到 nack 或 channel 关闭后再缓冲补发
+var errPublishUnconfirmed = errors.New("等待 broker 确认超时")
+
+func redisPendingPublishKey() string {
+	return fmt.Sprintf("%s:pending_publish", cfg.QueuePrefix)
+}
+
+// initRabbitMQ 建立首次连接并启动连接监督；首次连接失败时进入降级模式并在后台重连
+func initRabbitMQ() error {
+	rabbitQueueName = fmt.Sprintf("%s_tasks", cfg.QueuePrefix)
+	closed, err := connectRabbitMQ()
+	if err != nil {
+		rabbitDegraded.Store(true)
+	} else {
+		go flushPendingPublishes()
+	}
+	go superviseRabbitMQ(closed)
+	go flushPendingPeriodically()
+	return err
+}
+
+// connectRabbitMQ 建立连接与两个 channel，返回任一对象关闭时触发的通知
+func connectRabbitMQ() (<-chan *amqp.Error, error) {
+	conn, err := amqp.Dial(cfg.RabbitURL)
+	if err != nil {
+		return nil, fmt.Errorf("连接 RabbitMQ 失败: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("创建 RabbitMQ channel 失败: %w", err)
+	}
+	if _, err := ch.QueueDeclare(rabbitQueueName, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("声明 RabbitMQ 队列失败: %w", err)
+	}
+	if err := ch.Qos(1, 0, false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("设置 RabbitMQ Qos 失败: %w", err)
+	}
+	pub, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("创建 RabbitMQ 发布 channel 失败: %w", err)
+	}
+	if err := pub.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("开启 RabbitMQ publisher confirms 失败: %w", err)
+	}
+
+	// 合并连接与 channel 的关闭通知
+	closed := make(chan *amqp.Error, 1)
+	forward := func(src chan *amqp.Error) {
+		e := <-src
+		select {
+		case closed <- e:
+		default:
+		}
+	}
+	go forward(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	go forward(ch.NotifyClose(make(chan *amqp.Error, 1)))
+	go forward(pub.NotifyClose(make(chan *amqp.Error, 1)))
+
+	rabbitMu.Lock()
+	rabbitConn = conn
+	rabbitChannel = ch
+	rabbitPubChannel = pub
+	close(rabbitReconnected)
+	rabbitReconnected = make(chan struct{})
+	rabbitMu.Unlock()
+	rabbitDegraded.Store(false)
+	return closed, nil
+}
+
+// superviseRabbitMQ 在连接或 channel 关闭后按指数退避重连，并补发降级期间缓冲的消息
+func superviseRabbitMQ(closed <-chan *amqp.Error) {
+	for {
+		if closed != nil {
+			e := <-closed
+			if rabbitClosing.Load() {
+				return
+			}
+			rabbitDegraded.Store(true)
+			slog.Warn("RabbitMQ 连接已断开，开始重连", "err", e)
+			rabbitMu.Lock()
+			if rabbitConn != nil {
+				rabbitConn.Close()
+			}
+			rabbitMu.Unlock()
+		}
+
+		backoff := rabbitReconnectMin
+		for {
+			var err error
+			closed, err = connectRabbitMQ()
+			if err == nil {
+				break
+			}
+			slog.Error("RabbitMQ 重连失败", "err", err, "retry_in", backoff.String())
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > rabbitReconnectMax {
+				backoff = rabbitReconnectMax
+			}
+		}
+		slog.Info("RabbitMQ 已连接", "queue", rabbitQueueName)
+		go flushPendingPublishes()
+	}
+}
+
+// closeRabbitMQ 停机时主动关闭连接，不再重连
+func closeRabbitMQ() {
+	rabbitClosing.Store(true)
+	rabbitMu.Lock()
+	defer rabbitMu.Unlock()
+	if rabbitConn != nil && !rabbitConn.IsClosed() {
+		rabbitConn.Close()
+	}
+}
+
+func currentRabbitChannels() (*amqp.Connection, *amqp.Channel, *amqp.Channel) {
+	rabbitMu.RLock()
+	defer rabbitMu.RUnlock()
+	return rabbitConn, rabbitChannel, rabbitPubChannel
+}
+
+// waitRabbitReconnect 阻塞直到下一次重连成功或超时
+func waitRabbitReconnect(timeout time.Duration) {
+	rabbitMu.RLock()
+	ch := rabbitReconnected
+	rabbitMu.RUnlock()
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+}
+
+func publishConfirmed(body []byte) error {
+	_, _, pub := currentRabbitChannels()
+	if pub == nil || pub.IsClosed() {
+		return errors.New("RabbitMQ 发布通道不可用")
+	}
+	rabbitPubMu.Lock()
+	defer rabbitPubMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dc, err := pub.PublishWithDeferredConfirmWithContext(ctx, "", rabbitQueueName, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp.Persistent,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		go watchUnconfirmed(dc, body)
+		return errPublishUnconfirmed
+	}
+	if !acked {
+		return errors.New("broker 拒绝了消息 (nack)")
+	}
+	return nil
+}
+
+// watchUnconfirmed 等待超时未确认消息的最终结果；broker nack 或 channel 关闭（连接断开时未确认的消息一并视为 nack）才缓冲补发
+func watchUnconfirmed(dc *amqp.DeferredConfirmation, body []byte) {
+	if dc.Wait() {
+		return
+	}
+	if err := bufferPendingPublish(body); err != nil {
+		slog.Error("超时未确认的任务未被 broker 接收，缓冲失败", "err", err)
+		return
+	}
+	slog.Warn("超时未确认的任务未被 broker 接收，已缓冲待补发")
+	go flushPendingPublishes()
+}
+
+func bufferPendingPublish(body []byte) error {
+	if redisClient == nil {
+		return errors.New("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return redisClient.RPush(ctx, redisPendingPublishKey(), body).Err()
+}
+
+// publishTask 发布任务并等待 broker 确认；连接不可用或被 nack 时重试，仍失败则缓冲到 Redis 待补发。
+// 确认超时不重试，交由 watchUnconfirmed 处理
+func publishTask(t queuedTask) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 1; attempt <= rabbitPublishAttempts; attempt++ {
+		if lastErr = publishConfirmed(body); lastErr == nil {
+			return nil
+		}
+		if errors.Is(lastErr, errPublishUnconfirmed) {
+			slog.Warn("任务发布未及时确认，等待 broker 结果", "task_id", t.TaskID)
+			return nil
+		}
+		slog.Warn("任务发布失败", "task_id", t.TaskID, "attempt", attempt, "err", lastErr)
+		if attempt < rabbitPublishAttempts {
+			waitRabbitReconnect(2 * time.Second)
+		}
+	}
+	if redisClient == nil {
+		return lastErr
+	}
+	if err := bufferPendingPublish(body); err != nil {
+		return fmt.Errorf("%v；缓冲任务失败: %w", lastErr, err)
+	}
+	slog.Warn("RabbitMQ 不可用，任务已缓冲待补发", "task_id", t.TaskID, "err", lastErr)
+	return nil
+}
+
+// flushPendingPublishes 按顺序补发缓冲的任务，发布成功后才从缓冲中移除
+func flushPendingPublishes() {
+	if redisClient == nil || !rabbitFlushMu.TryLock() {
+		return
+	}
+	defer rabbitFlushMu.Unlock()
+	key := redisPendingPublishKey()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		body, err := redisClient.LIndex(ctx, key, 0).Bytes()
+		cancel()
+		if err != nil {
+			if err != redis.Nil {
+				slog.Error("读取待补发任务失败", "err", err)
+			}
+			return
+		}
+		// 确认超时的消息同样移出缓冲，是否重新缓冲由 watchUnconfirmed 决定
+		if err := publishConfirmed(body); err != nil && !errors.Is(err, errPublishUnconfirmed) {
+			slog.Warn("补发任务失败，稍后重试", "err", err)
+			return
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		err = redisClient.LPop(ctx, key).Err()
+		cancel()
+		if err != nil {
+			slog.Error("移除已补发任务失败", "err", err)
+			return
+		}
+		slog.Info("已补发缓冲任务")
+	}
+}
+
+// flushPendingPeriodically 连接可用时定期补发，停机后退出
+func flushPendingPeriodically() {
+	ticker := time.NewTicker(rabbitFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if rabbitClosing.Load() {
+			return
+		}
+		if !rabbitDegraded.Load() {
+			flushPendingPublishes()
+		}
+	}
+}
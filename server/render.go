@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// RenderOptions 提交给视频后端 /easy/submit 的渲染参数，提交任务时确定并随任务记录
+type RenderOptions struct {
+	Chaofen         int `json:"chaofen"`          // 超分辨率：0 关闭，1 开启
+	WatermarkSwitch int `json:"watermark_switch"` // 后端水印：0 关闭，1 开启
+	PN              int `json:"pn"`
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 引入渲染参数之前的任务固定使用的取值
+var legacyRenderOptions = RenderOptions{Chaofen: 0, WatermarkSwitch: 0, PN: 1}
+
+func (r RenderOptions) validate() error {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{{"chaofen", r.Chaofen}, {"watermark_switch", r.WatermarkSwitch}, {"pn", r.PN}} {
+		if f.value != 0 && f.value != 1 {
+			return fmt.Errorf("%s 仅支持 0 或 1: %d", f.name, f.value)
+		}
+	}
+	return nil
+}
+
+// RenderPreset 渲染参数预设，未设置的参数沿用上一层（服务默认值 → 用户默认预设 → 视频模版 → 指定预设 → 表单）
+type RenderPreset struct {
+	Chaofen         *int `json:"chaofen,omitempty"`
+	WatermarkSwitch *int `json:"watermark_switch,omitempty"`
+	PN              *int `json:"pn,omitempty"`
+}
+
+func (p *RenderPreset) empty() bool {
+	return p == nil || (p.Chaofen == nil && p.WatermarkSwitch == nil && p.PN == nil)
+}
+
+// applyTo 用预设中已设置的参数覆盖 r
+func (p *RenderPreset) applyTo(r *RenderOptions) {
+	if p == nil {
+		return
+	}
+	if p.Chaofen != nil {
+		r.Chaofen = *p.Chaofen
+	}
+	if p.WatermarkSwitch != nil {
+		r.WatermarkSwitch = *p.WatermarkSwitch
+	}
+	if p.PN != nil {
+		r.PN = *p.PN
+	}
+}
+
+// validate 只校验已设置的参数
+func (p *RenderPreset) validate() error {
+	var r RenderOptions
+	p.applyTo(&r)
+	return r.validate()
+}
+
+// 用户自动应用的预设名
+const defaultRenderPreset = "default"
+
+var renderPresetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+func renderPresetsKey(user string) string {
+	base := strings.TrimSpace(cfg.QueuePrefix)
+	if base == "" {
+		base = "digital_people"
+	}
+	return fmt.Sprintf("%s:render_presets:%s", base, user)
+}
+
+// loadRenderPresets 读取用户保存的全部渲染预设
+func loadRenderPresets(ctx context.Context, user string) (map[string]RenderPreset, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("Redis 未初始化")
+	}
+	raw, err := redisClient.HGetAll(ctx, renderPresetsKey(user)).Result()
+	if err != nil {
+		return nil, err
+	}
+	presets := make(map[string]RenderPreset, len(raw))
+	for name, data := range raw {
+		var p RenderPreset
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("渲染预设 %s 解析失败: %w", name, err)
+		}
+		presets[name] = p
+	}
+	return presets, nil
+}
+
+// loadRenderPreset 读取用户的一个预设，不存在时 ok 为 false
+func loadRenderPreset(ctx context.Context, user, name string) (p RenderPreset, ok bool, err error) {
+	if redisClient == nil {
+		return p, false, fmt.Errorf("Redis 未初始化")
+	}
+	data, err := redisClient.HGet(ctx, renderPresetsKey(user), name).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return p, false, nil
+		}
+		return p, false, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, false, fmt.Errorf("渲染预设 %s 解析失败: %w", name, err)
+	}
+	return p, true, nil
+}
+
+// renderPresetFromForm 读取表单中的 chaofen、watermark_switch、pn，未提交的字段为 nil
+func renderPresetFromForm(c *gin.Context) (*RenderPreset, error) {
+	p := &RenderPreset{}
+	for _, f := range []struct {
+		name string
+		dst  **int
+	}{{"chaofen", &p.Chaofen}, {"watermark_switch", &p.WatermarkSwitch}, {"pn", &p.PN}} {
+		raw := strings.TrimSpace(c.PostForm(f.name))
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s 须为整数: %q", f.name, raw)
+		}
+		*f.dst = &v
+	}
+	if p.empty() {
+		return nil, nil
+	}
+	return p, p.validate()
+}
+
+// resolveRenderOptions 按 服务默认值 → 用户 default 预设 → 视频模版 → render_preset 指定预设 → 表单字段 逐层确定渲染参数，
+// 返回实际使用的参数与指定的预设名
+func resolveRenderOptions(c *gin.Context, user string, template *RenderPreset) (RenderOptions, string, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	opts := cfg.RenderDefaults
+	// 默认预设不可读时（如 Redis 降级）仍可提交任务
+	if p, ok, err := loadRenderPreset(ctx, user, defaultRenderPreset); err != nil {
+		loggerFrom(c.Request.Context()).Warn("读取默认渲染预设失败", "user", user, "err", err)
+	} else if ok {
+		p.applyTo(&opts)
+	}
+	template.applyTo(&opts)
+
+	name := strings.TrimSpace(c.PostForm("render_preset"))
+	if name != "" {
+		p, ok, err := loadRenderPreset(ctx, user, name)
+		if err != nil {
+			return opts, name, fmt.Errorf("读取渲染预设失败: %v", err)
+		}
+		if !ok {
+			return opts, name, fmt.Errorf("渲染预设 %s 不存在", name)
+		}
+		p.applyTo(&opts)
+	}
+	form, err := renderPresetFromForm(c)
+	if err != nil {
+		return opts, name, err
+	}
+	form.applyTo(&opts)
+	return opts, name, opts.validate()
+}
+
+// renderPresetUser 预设按用户保存，未登录时返回 false
+func renderPresetUser(c *gin.Context) (string, bool) {
+	user := usernameFromContext(c)
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "请先登录"})
+		return "", false
+	}
+	return user, true
+}
+
+// GET /api/render/presets: 服务默认渲染参数与当前用户的预设
+func handleRenderPresetList(c *gin.Context) {
+	user, ok := renderPresetUser(c)
+	if !ok {
+		return
+	}
+	presets, err := loadRenderPresets(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取渲染预设失败: %v", err)})
+		return
+	}
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, gin.H{"defaults": cfg.RenderDefaults, "presets": presets, "names": names, "auto_preset": defaultRenderPreset})
+}
+
+// PUT /api/render/presets/:name: 保存预设，JSON {chaofen, watermark_switch, pn}，省略的参数沿用上一层
+func handleRenderPresetSave(c *gin.Context) {
+	user, ok := renderPresetUser(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	if !renderPresetNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "预设名仅支持 1-32 位字母、数字、下划线与连字符"})
+		return
+	}
+	var p RenderPreset
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if p.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "预设至少需要设置一个渲染参数"})
+		return
+	}
+	if err := p.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if redisClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis 未初始化"})
+		return
+	}
+	data, _ := json.Marshal(p)
+	if err := redisClient.HSet(c.Request.Context(), renderPresetsKey(user), name, data).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存渲染预设失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "渲染预设已保存", "name": name, "preset": p})
+}
+
+// DELETE /api/render/presets/:name
+func handleRenderPresetDelete(c *gin.Context) {
+	user, ok := renderPresetUser(c)
+	if !ok {
+		return
+	}
+	if redisClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis 未初始化"})
+		return
+	}
+	n, err := redisClient.HDel(c.Request.Context(), renderPresetsKey(user), c.Param("name")).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("删除渲染预设失败: %v", err)})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "渲染预设不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "渲染预设已删除"})
+}
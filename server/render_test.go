@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestRenderPresetApplyTo(t *testing.T) {
+	base := RenderOptions{Chaofen: 0, WatermarkSwitch: 1, PN: 1}
+	tests := []struct {
+		name   string
+		layers []*RenderPreset
+		want   RenderOptions
+	}{
+		{name: "nil 预设不改动", layers: []*RenderPreset{nil}, want: base},
+		{name: "空预设不改动", layers: []*RenderPreset{{}}, want: base},
+		{name: "只覆盖已设置的参数", layers: []*RenderPreset{{Chaofen: intPtr(1)}},
+			want: RenderOptions{Chaofen: 1, WatermarkSwitch: 1, PN: 1}},
+		{name: "设置为 0 也会覆盖", layers: []*RenderPreset{{WatermarkSwitch: intPtr(0), PN: intPtr(0)}},
+			want: RenderOptions{Chaofen: 0, WatermarkSwitch: 0, PN: 0}},
+		{name: "后一层覆盖前一层", layers: []*RenderPreset{
+			{Chaofen: intPtr(1), PN: intPtr(0)},
+			nil,
+			{Chaofen: intPtr(0)},
+		}, want: RenderOptions{Chaofen: 0, WatermarkSwitch: 1, PN: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := base
+			for _, p := range tt.layers {
+				p.applyTo(&got)
+			}
+			if got != tt.want {
+				t.Errorf("得到 %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPresetValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		preset  *RenderPreset
+		wantErr bool
+	}{
+		{name: "空预设", preset: &RenderPreset{}},
+		{name: "合法取值", preset: &RenderPreset{Chaofen: intPtr(1), WatermarkSwitch: intPtr(0), PN: intPtr(1)}},
+		{name: "chaofen 越界", preset: &RenderPreset{Chaofen: intPtr(2)}, wantErr: true},
+		{name: "pn 为负", preset: &RenderPreset{PN: intPtr(-1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.preset.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v，期望出错=%v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderPresetFromForm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    *RenderPreset
+		wantErr bool
+	}{
+		{name: "未提交", form: url.Values{}, want: nil},
+		{name: "空白视为未提交", form: url.Values{"chaofen": {" "}}, want: nil},
+		{name: "部分字段", form: url.Values{"chaofen": {"1"}, "pn": {" 0 "}},
+			want: &RenderPreset{Chaofen: intPtr(1), PN: intPtr(0)}},
+		{name: "非整数", form: url.Values{"watermark_switch": {"yes"}}, wantErr: true},
+		{name: "越界", form: url.Values{"pn": {"3"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.form.Encode()))
+			c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got, err := renderPresetFromForm(c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望出错，得到 %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("得到 %+v，期望 %+v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			var gotOpts, wantOpts RenderOptions
+			got.applyTo(&gotOpts)
+			tt.want.applyTo(&wantOpts)
+			if gotOpts != wantOpts || (got.Chaofen == nil) != (tt.want.Chaofen == nil) ||
+				(got.WatermarkSwitch == nil) != (tt.want.WatermarkSwitch == nil) || (got.PN == nil) != (tt.want.PN == nil) {
+				t.Errorf("得到 %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// renditionProfile 成片的一种派生输出
+type renditionProfile struct {
+	Ext   string
+	Label string
+	// args 由成片 src 生成 dst 的 ffmpeg 参数；skip 为片头时长，预览与封面从正片开始截取
+	args func(src, dst string, skip float64) []string
+}
+
+// 动图预览的时长（秒）
+const previewSeconds = 5
+
+// boxFilter 等比缩放到 w×h 内并补黑边，保证平台要求的固定分辨率
+func boxFilter(w, h int) string {
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1", w, h, w, h)
+}
+
+func videoRendition(filter, crf, audioBitrate string) func(src, dst string, skip float64) []string {
+	return func(src, dst string, _ float64) []string {
+		return []string{"-y", "-i", src, "-vf", filter,
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", crf, "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", audioBitrate, "-movflags", "+faststart", dst}
+	}
+}
+
+// seekArgs 覆盖输出并跳过片头
+func seekArgs(skip float64) []string {
+	if skip <= 0 {
+		return []string{"-y"}
+	}
+	return []string{"-y", "-ss", strconv.FormatFloat(skip, 'f', 3, 64)}
+}
+
+// renditionProfiles 内置输出规格
+var renditionProfiles = map[string]renditionProfile{
+	"vertical_1080": {Ext: ".mp4", Label: "1080x1920 H.264（短视频平台）",
+		args: videoRendition(boxFilter(1080, 1920), "20", "192k")},
+	"horizontal_1080": {Ext: ".mp4", Label: "1920x1080 H.264",
+		args: videoRendition(boxFilter(1920, 1080), "20", "192k")},
+	"preview_720": {Ext: ".mp4", Label: "短边 720 的低码率预览",
+		args: videoRendition("scale='if(gt(iw,ih),-2,720)':'if(gt(iw,ih),720,-2)',setsar=1", "28", "96k")},
+	"audio_mp3": {Ext: ".mp3", Label: "MP3 音频",
+		args: func(src, dst string, _ float64) []string {
+			return []string{"-y", "-i", src, "-vn", "-c:a", "libmp3lame", "-b:a", "192k", dst}
+		}},
+	"gif_preview": {Ext: ".gif", Label: "GIF 动图预览",
+		args: func(src, dst string, skip float64) []string {
+			args := append(seekArgs(skip), "-t", strconv.Itoa(previewSeconds), "-i", src)
+			return append(args, "-an",
+				"-vf", "fps=10,scale=360:-2:flags=lanczos,split[a][b];[a]palettegen[p];[b][p]paletteuse", "-loop", "0", dst)
+		}},
+	"webp_preview": {Ext: ".webp", Label: "WebP 动图预览",
+		args: func(src, dst string, skip float64) []string {
+			args := append(seekArgs(skip), "-t", strconv.Itoa(previewSeconds), "-i", src)
+			return append(args, "-an", "-vf", "fps=12,scale=360:-2", "-c:v", "libwebp", "-q:v", "60", "-loop", "0", dst)
+		}},
+	"poster": {Ext: ".jpg", Label: "JPEG 封面",
+		args: func(src, dst string, skip float64) []string {
+			args := append(seekArgs(skip), "-i", src)
+			return append(args, "-vf", "thumbnail", "-frames:v", "1", "-q:v", "2", dst)
+		}},
+}
+
+func renditionNames() []string {
+	names := make([]string, 0, len(renditionProfiles))
+	for name := range renditionProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseRenditions 解析逗号分隔的规格列表，去重并保持顺序；空串或 none 表示不生成
+func parseRenditions(raw string) ([]string, error) {
+	var out []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" || name == "none" || slices.Contains(out, name) {
+			continue
+		}
+		if _, ok := renditionProfiles[name]; !ok {
+			return nil, fmt.Errorf("未知的输出规格: %q（可选 %s）", name, strings.Join(renditionNames(), "、"))
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// renditionsFromForm 读取 renditions，未提交时使用 OUTPUT_RENDITIONS
+func renditionsFromForm(c *gin.Context) ([]string, error) {
+	raw, ok := c.GetPostForm("renditions")
+	if !ok {
+		raw = cfg.OutputRenditions
+	}
+	return parseRenditions(raw)
+}
+
+// renditionFilename 派生输出的文件名：<成片名>.<规格><扩展名>
+func renditionFilename(resultFilename, profile string) string {
+	return strings.TrimSuffix(resultFilename, filepath.Ext(resultFilename)) + "." + profile + renditionProfiles[profile].Ext
+}
+
+// generateRenditions 由最终成片逐个生成派生输出并记录媒体信息，视频规格另做轻量校验；单个规格失败只记录在该规格的 error
+func generateRenditions(ctx context.Context, status *AutoProcessStatus, profiles []string, videoPath string, skip float64) {
+	status.CurrentStep = "生成输出规格"
+	status.Progress = 99
+	status.beginStage(stageRenditions)
+	persistTaskStatus(status)
+
+	status.Renditions = nil
+	failed := 0
+	source := 0.0
+	if probe, err := probeMedia(ctx, videoPath); err == nil {
+		source = probe.duration()
+	}
+	for _, name := range profiles {
+		profile := renditionProfiles[name]
+		filename := renditionFilename(filepath.Base(videoPath), name)
+		dst := filepath.Join(filepath.Dir(videoPath), filename)
+		r := RenditionResult{Profile: name}
+		if _, stderr, err := run(ctx, "ffmpeg", profile.args(videoPath, dst, skip)...); err != nil {
+			os.Remove(dst)
+			r.Error = truncateSnippet(fmt.Sprintf("%v | %s", err, stderr))
+			failed++
+			taskLog(ctx, status).Warn("输出规格生成失败", "profile", name, "err", err)
+			status.Renditions = append(status.Renditions, r)
+			continue
+		}
+		var probe *mediaProbe
+		if profile.Ext == ".mp4" {
+			var err error
+			if probe, err = checkVideoRendition(ctx, dst, source); err != nil {
+				os.Remove(dst)
+				r.Error = truncateSnippet(fmt.Sprintf("校验失败: %v", err))
+				failed++
+				taskLog(ctx, status).Warn("输出规格校验失败", "profile", name, "err", err)
+				status.Renditions = append(status.Renditions, r)
+				continue
+			}
+		} else if p, err := probeMedia(ctx, dst); err == nil {
+			probe = p
+		}
+		r.Filename = filename
+		if st, err := os.Stat(dst); err == nil {
+			r.SizeBytes = st.Size()
+		}
+		if probe != nil {
+			r.Duration = math.Round(probe.duration()*1000) / 1000
+			if v := probe.stream("video"); v != nil {
+				r.Width, r.Height = v.Width, v.Height
+			}
+		}
+		status.Renditions = append(status.Renditions, r)
+	}
+	status.noteStage(fmt.Sprintf("%d 个规格，失败 %d 个", len(profiles), failed))
+}
+
+// GET /api/auto/renditions: 可选的输出规格
+func handleRenditionProfiles(c *gin.Context) {
+	type profileInfo struct {
+		Name  string `json:"name"`
+		Ext   string `json:"ext"`
+		Label string `json:"label"`
+	}
+	var profiles []profileInfo
+	for _, name := range renditionNames() {
+		p := renditionProfiles[name]
+		profiles = append(profiles, profileInfo{Name: name, Ext: p.Ext, Label: p.Label})
+	}
+	defaults, _ := parseRenditions(cfg.OutputRenditions)
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "default": defaults})
+}
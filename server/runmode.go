@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RunMode 决定进程承担的角色：serve 只提供 HTTP 接口并投递任务，worker 只消费队列执行流水线
+type RunMode string
+
+const (
+	runModeServe  RunMode = "serve"
+	runModeWorker RunMode = "worker"
+	runModeAll    RunMode = "all"
+)
+
+var runMode = runModeAll
+
+func (m RunMode) servesAPI() bool  { return m == runModeServe || m == runModeAll }
+func (m RunMode) runsWorker() bool { return m == runModeWorker || m == runModeAll }
+
+// parseRunMode 优先读取子命令（heygem serve|worker|all），其次 RUN_MODE 环境变量，默认 all
+func parseRunMode(args []string) RunMode {
+	raw := getenv("RUN_MODE", string(runModeAll))
+	if len(args) > 0 && args[0] != "" {
+		raw = args[0]
+	}
+	switch m := RunMode(raw); m {
+	case runModeServe, runModeWorker, runModeAll:
+		return m
+	}
+	fatal("未知的运行模式", fmt.Errorf("%q，可选 serve|worker|all", raw))
+	return ""
+}
+
+// validateConfig 按运行模式校验配置，只检查该模式实际用到的项
+func validateConfig(mode RunMode) error {
+	var errs []error
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("APP_PORT 非法: %q", cfg.Port))
+	}
+	if cfg.RedisAddr == "" {
+		errs = append(errs, errors.New("REDIS_ADDR 不能为空"))
+	}
+	if _, err := url.Parse(cfg.RabbitURL); err != nil || cfg.RabbitURL == "" {
+		errs = append(errs, errors.New("RABBITMQ_URL 非法"))
+	}
+	// serve 用 ffprobe 校验上传素材，worker 的时长探测、成片校验、合成与字幕同样依赖它
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		errs = append(errs, fmt.Errorf("%s 模式需要 ffprobe", mode))
+	}
+	if mode.servesAPI() {
+		if cfg.UsersFile == "" {
+			errs = append(errs, errors.New("USERS_FILE 不能为空"))
+		}
+		if cfg.StaticDir != "" {
+			if st, err := os.Stat(cfg.StaticDir); err != nil || !st.IsDir() {
+				errs = append(errs, fmt.Errorf("STATIC_DIR 不存在: %s", cfg.StaticDir))
+			}
+		}
+		switch cfg.VideoFill {
+		case videoFillNone, videoFillLoop, videoFillPingPong:
+		default:
+			errs = append(errs, fmt.Errorf("VIDEO_FILL 非法: %q", cfg.VideoFill))
+		}
+		if _, err := parseRenditions(cfg.OutputRenditions); err != nil {
+			errs = append(errs, fmt.Errorf("OUTPUT_RENDITIONS 配置错误: %v", err))
+		}
+		if err := cfg.ComposeDefaults.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("COMPOSE_* 配置错误: %v", err))
+		}
+		if _, ok := subtitleStyles[cfg.SubtitleStyle]; !ok {
+			errs = append(errs, fmt.Errorf("SUBTITLE_STYLE 非法: %q", cfg.SubtitleStyle))
+		}
+		if lim := cfg.InputLimits; lim.MaxAspect > 0 && lim.MinAspect > lim.MaxAspect {
+			errs = append(errs, fmt.Errorf("INPUT_MIN_ASPECT (%.2f) 不能大于 INPUT_MAX_ASPECT (%.2f)", lim.MinAspect, lim.MaxAspect))
+		}
+	}
+	if mode.runsWorker() {
+		for _, b := range cfg.TTSBackends {
+			if u, err := url.Parse(b.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("TTS 实例 %s 的地址非法: %q", b.Name, b.BaseURL))
+			}
+		}
+		if cfg.TTSBalance != ttsBalanceLeastInflight && cfg.TTSBalance != ttsBalanceRoundRobin {
+			errs = append(errs, fmt.Errorf("TTS_BALANCE 非法: %q", cfg.TTSBalance))
+		}
+		for _, b := range cfg.VideoBackends {
+			if u, err := url.Parse(b.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("视频后端 %s 的 base_url 非法: %q", b.Name, b.BaseURL))
+			}
+			if b.Container == "" {
+				errs = append(errs, fmt.Errorf("视频后端 %s 未配置 container", b.Name))
+			}
+			if b.DataRoot == "" {
+				errs = append(errs, fmt.Errorf("视频后端 %s 未配置 data_root", b.Name))
+			}
+		}
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			errs = append(errs, errors.New("worker 需要 ffmpeg"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// worker 能力：拉取渲染结果需要其一
+const (
+	capLocalResultDir = "local_result_dir" // 可直接读取视频服务挂载的 temp 目录
+	capDocker         = "docker"           // 可通过 docker exec/cp 访问渲染容器
+)
+
+// detectCapabilities 逐个视频后端探测本机能力并写入注册信息，返回全部后端能力的并集
+func detectCapabilities() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	perBackend := map[string][]string{}
+	var caps []string
+	for _, b := range cfg.VideoBackends {
+		var bc []string
+		if st, err := os.Stat(filepath.Join(b.HostVideoDir, "temp")); err == nil && st.IsDir() {
+			bc = append(bc, capLocalResultDir)
+		}
+		if _, _, err := run(ctx, "docker", "inspect", "-f", "{{.State.Status}}", b.Container); err == nil {
+			bc = append(bc, capDocker)
+		}
+		if len(bc) > 0 {
+			perBackend[b.Name] = bc
+		}
+		for _, c := range bc {
+			if !slices.Contains(caps, c) {
+				caps = append(caps, c)
+			}
+		}
+	}
+	slices.Sort(caps)
+	workerMu.Lock()
+	changed := !maps.EqualFunc(workerSelf.Backends, perBackend, slices.Equal[[]string])
+	workerSelf.Capabilities = caps
+	workerSelf.Backends = perBackend
+	workerMu.Unlock()
+	if changed {
+		slog.Info("worker 能力", "worker_id", workerID, "backends", perBackend)
+	}
+	return caps
+}
+
+// backendHasCapability 判断本 worker 对指定视频后端是否具备某项能力
+func backendHasCapability(backend, name string) bool {
+	workerMu.Lock()
+	defer workerMu.Unlock()
+	return slices.Contains(workerSelf.Backends[backend], name)
+}
+
+// canFetchFromBackend 判断本 worker 能否拉取指定视频后端的渲染结果
+func canFetchFromBackend(backend string) bool {
+	workerMu.Lock()
+	defer workerMu.Unlock()
+	return canFetchResults(workerSelf.Backends[backend])
+}
+
+func canFetchResults(caps []string) bool {
+	return slices.Contains(caps, capLocalResultDir) || slices.Contains(caps, capDocker)
+}
+
+// hasFetchCapableWorker 判断当前是否有能拉取结果的 worker 在线
+func hasFetchCapableWorker(ctx context.Context) bool {
+	workers, err := listLiveWorkers(ctx)
+	if err != nil {
+		return true // 无法判断时不影响投递
+	}
+	for _, w := range workers {
+		if canFetchResults(w.Capabilities) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 检查点：渲染任务已提交到视频服务，恢复时无需重新预处理与提交
+const checkpointSubmitted = "render_submitted"
+
+const stageOutcomeInterrupted = "interrupted"
+
+// 停机超时后留给任务写入检查点、退回消息的时间
+const handOverGrace = 15 * time.Second
+
+// handOverTask 在停机超时中断时把任务恢复为排队状态
+func handOverTask(ctx context.Context, status *AutoProcessStatus) {
+	status.Status = "queued"
+	status.WorkerID = ""
+	status.Error = ""
+	status.CurrentStep = "服务停止，任务已交回队列"
+	status.endStage(stageOutcomeInterrupted, "")
+	status.beginStage(stageQueueWait)
+	// 尚未提交渲染的任务不占用后端，释放负载以免其他 worker 误判；
+	// 已提交的渲染仍在后端执行，保留负载，由接手的 worker 继续持有
+	if status.Checkpoint != checkpointSubmitted {
+		releaseVideoBackend(status.TaskID, status.VideoBackend)
+	}
+	taskLog(ctx, status).Warn("停机超时，任务交回队列", "checkpoint", status.Checkpoint)
+}
+
+// requeueStaleTask 重新投递失联任务；缺少重放信息时直接标记失败
+func requeueStaleTask(st *AutoProcessStatus, step string) {
+	lg := slog.Default().With("task_id", st.TaskID)
+	st.endStage(stageOutcomeInterrupted, "")
+	if st.Request == nil || st.AudioPath == "" || st.VideoPath == "" {
+		st.Status = "failed"
+		st.Error = "任务中断且缺少重放所需信息"
+		st.EndTime = time.Now().Unix()
+		st.TotalDuration = st.EndTime - st.StartTime
+		observeTaskOutcome(st)
+		persistTaskStatus(st)
+		lg.Warn("中断任务无法重放，已标记失败")
+		return
+	}
+	st.Status = "queued"
+	st.CurrentStep = step
+	st.beginStage(stageQueueWait)
+	t := queuedTask{
+		TaskID:    st.TaskID,
+		AudioPath: st.AudioPath,
+		VideoPath: st.VideoPath,
+		Req:       *st.Request,
+		RequestID: newRequestID(),
+	}
+	if err := enqueueTask(st, t); err != nil {
+		lg.Error("中断任务重新入队失败", "err", err)
+		return
+	}
+	lg.Info("中断任务已重新入队", "checkpoint", st.Checkpoint)
+}
+
+func waitWorkerIdle(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		workerWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// gracefulShutdown 停止接收请求与消费，在 cfg.ShutdownTimeout 内等待任务完成，
+// 超时则中断任务、写入检查点并退回队列
+func gracefulShutdown(srv *http.Server, cancelTasks context.CancelFunc) {
+	slog.Info("收到退出信号，开始优雅关闭", "timeout", cfg.ShutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	if srv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Warn("HTTP 服务关闭超时", "err", err)
+			}
+		}()
+	}
+
+	if !waitWorkerIdle(ctx) {
+		slog.Warn("等待任务完成超时，中断任务并交回队列")
+		cancelTasks()
+		graceCtx, graceCancel := context.WithTimeout(context.Background(), handOverGrace)
+		if !waitWorkerIdle(graceCtx) {
+			slog.Error("任务未能在限定时间内交回，将依赖重启后的中断任务恢复")
+		}
+		graceCancel()
+	}
+	wg.Wait()
+
+	closeRabbitMQ()
+	unregisterWorker()
+	if redisClient != nil {
+		redisClient.Close()
+	}
+	slog.Info("服务已停止")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 流水线阶段名称（按执行顺序）
+const (
+	stageQueueWait     = "queue_wait"
+	stageAudioPrepare  = "audio_prepare"
+	stageVideoPrepare  = "video_prepare"
+	stageTTSPreprocess = "tts_preprocess"
+	stageTTSInvoke     = "tts_invoke"
+	stageVideoFit      = "video_fit"
+	stageVideoSubmit   = "video_submit"
+	stageVideoRender   = "video_render"
+	stageResultFetch   = "result_fetch"
+	stageResultVerify  = "result_verify"
+	stageSubtitles     = "subtitles"
+	stageCompose       = "compose"
+	stageRenditions    = "renditions"
+)
+
+var stageOrder = []string{
+	stageQueueWait,
+	stageAudioPrepare,
+	stageVideoPrepare,
+	stageTTSPreprocess,
+	stageTTSInvoke,
+	stageVideoFit,
+	stageVideoSubmit,
+	stageVideoRender,
+	stageResultFetch,
+	stageResultVerify,
+	stageSubtitles,
+	stageCompose,
+	stageRenditions,
+}
+
+const (
+	stageOutcomeRunning = "running"
+	stageOutcomeOK      = "ok"
+	stageOutcomeFailed  = "failed"
+)
+
+// 上游响应片段最大保留长度，避免把音视频内容写进 Redis
+const stageSnippetLimit = 512
+
+func truncateSnippet(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= stageSnippetLimit {
+		return s
+	}
+	// 按 rune 边界截断，避免产生非法 UTF-8
+	cut := stageSnippetLimit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// openStage 返回当前未结束的阶段，没有则返回 nil
+func (s *AutoProcessStatus) openStage() *StageRecord {
+	if n := len(s.Stages); n > 0 && s.Stages[n-1].Outcome == stageOutcomeRunning {
+		return &s.Stages[n-1]
+	}
+	return nil
+}
+
+// beginStage 结束上一个阶段（视为成功）并开启新阶段；attempt 按同名阶段累计（含重试）
+func (s *AutoProcessStatus) beginStage(name string) {
+	s.endStage(stageOutcomeOK, "")
+	attempt := 1
+	for _, rec := range s.Stages {
+		if rec.Name == name {
+			attempt++
+		}
+	}
+	s.Stages = append(s.Stages, StageRecord{
+		Name:    name,
+		Attempt: attempt,
+		StartMs: time.Now().UnixMilli(),
+		Outcome: stageOutcomeRunning,
+	})
+	trackStageBegin(s.TaskID, name)
+}
+
+// endStage 结束当前阶段，errMsg 仅在失败时记录
+func (s *AutoProcessStatus) endStage(outcome, errMsg string) {
+	rec := s.openStage()
+	if rec == nil {
+		return
+	}
+	rec.EndMs = time.Now().UnixMilli()
+	rec.DurationMs = rec.EndMs - rec.StartMs
+	rec.Outcome = outcome
+	if outcome == stageOutcomeFailed {
+		rec.Error = truncateSnippet(errMsg)
+	}
+	trackStageEnd(s.TaskID)
+	observeStage(rec)
+}
+
+// noteStage 为当前阶段记录上游响应片段
+func (s *AutoProcessStatus) noteStage(detail string) {
+	if rec := s.openStage(); rec != nil {
+		rec.Detail = truncateSnippet(detail)
+	}
+}
+
+// markCacheHit 标记当前阶段的产出来自媒体缓存
+func (s *AutoProcessStatus) markCacheHit() {
+	if rec := s.openStage(); rec != nil {
+		rec.CacheHit = true
+	}
+}
+
+type stageLatency struct {
+	Stage  string `json:"stage"`
+	Count  int    `json:"count"`
+	OK     int    `json:"ok"`
+	Failed int    `json:"failed"`
+	// CacheHits 为产出来自媒体缓存的次数
+	CacheHits int   `json:"cache_hits"`
+	AvgMs     int64 `json:"avg_ms"`
+	P50Ms     int64 `json:"p50_ms"`
+	P95Ms     int64 `json:"p95_ms"`
+	MaxMs     int64 `json:"max_ms"`
+}
+
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func aggregateStageLatency(statuses []*AutoProcessStatus) []stageLatency {
+	durations := map[string][]int64{}
+	report := map[string]*stageLatency{}
+	for _, st := range statuses {
+		for _, rec := range st.Stages {
+			if rec.Outcome == stageOutcomeRunning {
+				continue
+			}
+			item, ok := report[rec.Name]
+			if !ok {
+				item = &stageLatency{Stage: rec.Name}
+				report[rec.Name] = item
+			}
+			item.Count++
+			if rec.Outcome == stageOutcomeOK {
+				item.OK++
+			} else {
+				item.Failed++
+			}
+			if rec.CacheHit {
+				item.CacheHits++
+			}
+			durations[rec.Name] = append(durations[rec.Name], rec.DurationMs)
+		}
+	}
+
+	names := make([]string, 0, len(report))
+	for _, name := range stageOrder {
+		if _, ok := report[name]; ok {
+			names = append(names, name)
+		}
+	}
+	var extra []string
+	for name := range report {
+		known := false
+		for _, n := range stageOrder {
+			if n == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	result := make([]stageLatency, 0, len(names))
+	for _, name := range names {
+		item := report[name]
+		ds := durations[name]
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		var sum int64
+		for _, d := range ds {
+			sum += d
+		}
+		item.AvgMs = sum / int64(len(ds))
+		item.P50Ms = percentile(ds, 0.5)
+		item.P95Ms = percentile(ds, 0.95)
+		item.MaxMs = ds[len(ds)-1]
+		result = append(result, *item)
+	}
+	return result
+}
+
+// 阶段统计最多读取的任务数，避免一次从 Redis 加载过多状态
+const maxStageReportLimit = 1000
+
+// GET /api/auto/stats/stages?limit=200: 最近任务的分阶段耗时统计
+func handleStageLatencyReport(c *gin.Context) {
+	limit := 200
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxStageReportLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit 必须为 1-%d 的整数", maxStageReportLimit)})
+			return
+		}
+		limit = parsed
+	}
+	statuses, err := listRecentTaskStatuses(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取任务列表失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tasks": len(statuses), "stages": aggregateStageLatency(statuses)})
+}
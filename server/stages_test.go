@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []int64
+		p      float64
+		want   int64
+	}{
+		{name: "空", sorted: nil, p: 0.5, want: 0},
+		{name: "单个值", sorted: []int64{7}, p: 0.95, want: 7},
+		{name: "中位数向下取", sorted: []int64{1, 2, 3, 4}, p: 0.5, want: 2},
+		{name: "p95", sorted: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}, p: 0.95, want: 19},
+		{name: "最大值", sorted: []int64{1, 2, 3}, p: 1, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %d，期望 %d", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateStageLatency(t *testing.T) {
+	statuses := []*AutoProcessStatus{
+		{Stages: []StageRecord{
+			{Name: stageTTSInvoke, Outcome: stageOutcomeOK, DurationMs: 300},
+			{Name: stageAudioPrepare, Outcome: stageOutcomeOK, DurationMs: 10, CacheHit: true},
+			{Name: "legacy_b", Outcome: stageOutcomeOK, DurationMs: 5},
+		}},
+		{Stages: []StageRecord{
+			{Name: stageAudioPrepare, Outcome: stageOutcomeOK, DurationMs: 30},
+			{Name: stageTTSInvoke, Outcome: stageOutcomeFailed, DurationMs: 100},
+			{Name: "legacy_a", Outcome: stageOutcomeFailed, DurationMs: 1},
+		}},
+		{Stages: []StageRecord{
+			{Name: stageTTSInvoke, Outcome: stageOutcomeOK, DurationMs: 200},
+			// 进行中的阶段不计入
+			{Name: stageVideoRender, Outcome: stageOutcomeRunning},
+		}},
+	}
+	want := []stageLatency{
+		{Stage: stageAudioPrepare, Count: 2, OK: 2, CacheHits: 1, AvgMs: 20, P50Ms: 10, P95Ms: 10, MaxMs: 30},
+		{Stage: stageTTSInvoke, Count: 3, OK: 2, Failed: 1, AvgMs: 200, P50Ms: 200, P95Ms: 200, MaxMs: 300},
+		// 不在 stageOrder 中的阶段按名称排在最后
+		{Stage: "legacy_a", Count: 1, Failed: 1, AvgMs: 1, P50Ms: 1, P95Ms: 1, MaxMs: 1},
+		{Stage: "legacy_b", Count: 1, OK: 1, AvgMs: 5, P50Ms: 5, P95Ms: 5, MaxMs: 5},
+	}
+	if got := aggregateStageLatency(statuses); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateStageLatency =\n%+v\n期望\n%+v", got, want)
+	}
+	if got := aggregateStageLatency(nil); len(got) != 0 {
+		t.Errorf("无任务时应返回空报告，得到 %+v", got)
+	}
+}
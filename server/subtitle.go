@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubtitleOptions 成片字幕选项，提交任务时确定并随任务记录
+type SubtitleOptions struct {
+	Mode     string `json:"mode"`                // sidecar：只输出 SRT/VTT；burn：同时烧录进视频
+	Style    string `json:"style,omitempty"`     // 样式预设，见 subtitleStyles
+	FontSize int    `json:"font_size,omitempty"` // 覆盖预设字号
+	Color    string `json:"color,omitempty"`     // 覆盖预设文字颜色 RRGGBB
+	Position string `json:"position,omitempty"`  // bottom | middle | top
+	Text     string `json:"text,omitempty"`      // 字幕文本；为空时使用 TTS 文本
+}
+
+const (
+	subtitleModeNone    = "none"
+	subtitleModeSidecar = "sidecar"
+	subtitleModeBurn    = "burn"
+)
+
+// subtitleStyle 烧录字幕的 ASS 样式参数，颜色为 RRGGBB
+type subtitleStyle struct {
+	FontSize  int
+	Color     string
+	Outline   int
+	Bold      bool
+	Boxed     bool // 半透明底框代替描边
+	Position  string
+	MarginV   int
+	BoxColour string // ASS &HAABBGGRR
+}
+
+// subtitleStyles 内置样式预设，字号以 ASS 默认 288 高的画布为基准，随视频等比缩放
+var subtitleStyles = map[string]subtitleStyle{
+	"default": {FontSize: 16, Color: "FFFFFF", Outline: 1, Position: "bottom", MarginV: 20},
+	"bold":    {FontSize: 20, Color: "FFFFFF", Outline: 2, Bold: true, Position: "bottom", MarginV: 24},
+	"boxed":   {FontSize: 16, Color: "FFFFFF", Boxed: true, Position: "bottom", MarginV: 20, BoxColour: "&H80000000"},
+	"yellow":  {FontSize: 18, Color: "FFE14D", Outline: 2, Bold: true, Position: "bottom", MarginV: 24},
+}
+
+var colorPattern = regexp.MustCompile(`^#?[0-9A-Fa-f]{6}$`)
+
+// subtitleOptionsFromForm 读取 subtitles、subtitle_style、subtitle_font_size、subtitle_color、subtitle_position、subtitle_text；
+// 未开启字幕时返回 nil
+func subtitleOptionsFromForm(c *gin.Context) (*SubtitleOptions, error) {
+	mode := strings.ToLower(strings.TrimSpace(c.PostForm("subtitles")))
+	switch mode {
+	case "", subtitleModeNone, "false":
+		return nil, nil
+	case subtitleModeSidecar, subtitleModeBurn:
+	default:
+		return nil, fmt.Errorf("subtitles 仅支持 none|sidecar|burn: %q", mode)
+	}
+	o := &SubtitleOptions{
+		Mode:     mode,
+		Style:    strings.TrimSpace(c.PostForm("subtitle_style")),
+		Color:    strings.TrimPrefix(strings.TrimSpace(c.PostForm("subtitle_color")), "#"),
+		Position: strings.TrimSpace(c.PostForm("subtitle_position")),
+		Text:     strings.TrimSpace(c.PostForm("subtitle_text")),
+	}
+	if o.Style == "" {
+		o.Style = cfg.SubtitleStyle
+	}
+	if _, ok := subtitleStyles[o.Style]; !ok {
+		return nil, fmt.Errorf("未知的字幕样式: %q（可选 %s）", o.Style, strings.Join(subtitleStyleNames(), "、"))
+	}
+	if v := strings.TrimSpace(c.PostForm("subtitle_font_size")); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 8 || size > 72 {
+			return nil, fmt.Errorf("subtitle_font_size 取值 8-72: %q", v)
+		}
+		o.FontSize = size
+	}
+	if o.Color != "" && !colorPattern.MatchString(o.Color) {
+		return nil, fmt.Errorf("subtitle_color 格式为 RRGGBB: %q", o.Color)
+	}
+	switch o.Position {
+	case "", "bottom", "middle", "top":
+	default:
+		return nil, fmt.Errorf("subtitle_position 仅支持 bottom|middle|top: %q", o.Position)
+	}
+	return o, nil
+}
+
+func subtitleStyleNames() []string {
+	return []string{"default", "bold", "boxed", "yellow"}
+}
+
+// resolvedStyle 预设叠加请求中的覆盖项
+func (o *SubtitleOptions) resolvedStyle() subtitleStyle {
+	s := subtitleStyles[o.Style]
+	if o.FontSize > 0 {
+		s.FontSize = o.FontSize
+	}
+	if o.Color != "" {
+		s.Color = strings.ToUpper(o.Color)
+	}
+	if o.Position != "" {
+		s.Position = o.Position
+	}
+	return s
+}
+
+// forceStyle 拼接 subtitles 滤镜的 force_style 参数
+func (s subtitleStyle) forceStyle(font string) string {
+	// ASS 颜色为 &HAABBGGRR
+	primary := "&H00" + s.Color[4:6] + s.Color[2:4] + s.Color[0:2]
+	alignment := map[string]int{"bottom": 2, "middle": 5, "top": 8}[s.Position]
+	parts := []string{
+		"FontName=" + font,
+		fmt.Sprintf("FontSize=%d", s.FontSize),
+		"PrimaryColour=" + primary,
+		fmt.Sprintf("Alignment=%d", alignment),
+		fmt.Sprintf("MarginV=%d", s.MarginV),
+	}
+	if s.Bold {
+		parts = append(parts, "Bold=1")
+	}
+	if s.Boxed {
+		parts = append(parts, "BorderStyle=3", "Outline=1", "Shadow=0", "OutlineColour="+s.BoxColour, "BackColour="+s.BoxColour)
+	} else {
+		parts = append(parts, "BorderStyle=1", fmt.Sprintf("Outline=%d", s.Outline), "Shadow=0", "OutlineColour=&H00000000")
+	}
+	return strings.Join(parts, ",")
+}
+
+// subtitleCue 一条字幕
+type subtitleCue struct {
+	Start, End float64
+	Text       string
+}
+
+// 句末标点断句，句内停顿标点用于过长句的二次切分
+const (
+	sentenceBreaks = "。！？；!?;…\n"
+	clauseBreaks   = "，、,：:"
+)
+
+// splitSubtitleText 按句切分，过长的句子再按逗号或字数切分，每条不超过 maxRunes 字
+func splitSubtitleText(text string, maxRunes int) []string {
+	var lines []string
+	for _, sentence := range splitKeep(text, sentenceBreaks) {
+		for _, clause := range packClauses(splitKeep(sentence, clauseBreaks), maxRunes) {
+			// 无标点的长句按字数均分，避免最后一条只剩几个字
+			r := []rune(clause)
+			parts := (len(r) + maxRunes - 1) / maxRunes
+			for i := 0; i < parts; i++ {
+				if line := trimCuePunct(string(r[len(r)*i/parts : len(r)*(i+1)/parts])); line != "" {
+					lines = append(lines, line)
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// splitKeep 在分隔符后切开，分隔符保留在前一段末尾
+func splitKeep(s, seps string) []string {
+	var out []string
+	start := 0
+	for i, r := range s {
+		if strings.ContainsRune(seps, r) {
+			end := i + utf8.RuneLen(r)
+			out = append(out, s[start:end])
+			start = end
+		}
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
+
+// packClauses 相邻短分句合并成一条，直到超过 maxRunes
+func packClauses(clauses []string, maxRunes int) []string {
+	var out []string
+	cur := ""
+	for _, c := range clauses {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if cur != "" && utf8.RuneCountInString(cur+c) > maxRunes {
+			out = append(out, cur)
+			cur = ""
+		}
+		cur += c
+	}
+	if cur != "" {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// trimCuePunct 去掉字幕行首尾的空白与停顿标点（保留问号、叹号）
+func trimCuePunct(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("。，、；;,.：:…", r)
+	})
+}
+
+// cueWeight 估算朗读时长的权重：汉字按 1，连续的字母数字按词计 1.5，标点按停顿计 0.5
+func cueWeight(s string) float64 {
+	w := 0.0
+	inWord := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			w++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				w += 1.5
+			}
+			inWord = true
+		case unicode.IsPunct(r):
+			w += 0.5
+			inWord = false
+		default:
+			inWord = false
+		}
+	}
+	return math.Max(w, 1)
+}
+
+// silenceSpan ffmpeg silencedetect 检测出的一段静音
+type silenceSpan struct{ Start, End float64 }
+
+var silenceLogPattern = regexp.MustCompile(`silence_(start|end): (-?[0-9.]+)`)
+
+// detectSilences 检测音轨中的停顿，用于把字幕边界对齐到实际断句处
+func detectSilences(ctx context.Context, path string) ([]silenceSpan, error) {
+	_, stderr, err := run(ctx, "ffmpeg", "-hide_banner", "-nostats", "-i", path, "-vn", "-af", "silencedetect=noise=-35dB:d=0.2", "-f", "null", "-")
+	if err != nil {
+		return nil, fmt.Errorf("%v | %s", err, truncateSnippet(stderr))
+	}
+	var spans []silenceSpan
+	for _, m := range silenceLogPattern.FindAllStringSubmatch(stderr, -1) {
+		t, _ := strconv.ParseFloat(m[2], 64)
+		if m[1] == "start" {
+			spans = append(spans, silenceSpan{Start: math.Max(t, 0), End: -1})
+		} else if n := len(spans); n > 0 && spans[n-1].End < 0 {
+			spans[n-1].End = t
+		}
+	}
+	return spans, nil
+}
+
+// alignCues 在有声区间内按朗读权重分配时长，再把每个分界吸附到附近的停顿中点。
+// 这是没有语音识别时间戳（自带音频的任务）时的启发式对齐，脚本较长或语速不均时字幕会逐渐偏离，
+// 停顿吸附只能在每个分界附近修正
+func alignCues(lines []string, duration float64, silences []silenceSpan) []subtitleCue {
+	// 音轨结尾的静音没有 silence_end
+	for i := range silences {
+		if silences[i].End < 0 {
+			silences[i].End = duration
+		}
+	}
+	speechStart, speechEnd := 0.0, duration
+	if n := len(silences); n > 0 {
+		if silences[0].Start <= 0.05 {
+			speechStart = silences[0].End
+		}
+		if silences[n-1].End >= duration-0.05 && silences[n-1].Start > speechStart {
+			speechEnd = silences[n-1].Start
+		}
+	}
+	total := 0.0
+	for _, l := range lines {
+		total += cueWeight(l)
+	}
+	span := speechEnd - speechStart
+	cues := make([]subtitleCue, len(lines))
+	t, acc := speechStart, 0.0
+	for i, l := range lines {
+		acc += cueWeight(l)
+		end := speechStart + span*acc/total
+		if i < len(lines)-1 {
+			end = snapToSilence(end, silences, t, 0.8)
+		} else {
+			end = speechEnd
+		}
+		cues[i] = subtitleCue{Start: t, End: end, Text: l}
+		t = end
+	}
+	return cues
+}
+
+// alignCuesToTimeline 按 TTS 语音识别的逐句时间戳对齐字幕：把字幕文本与识别文本按朗读权重对应，
+// 字幕分界落在某句识别结果内时在该句起止时间之间插值，落在句间停顿附近时对齐到停顿两侧
+func alignCuesToTimeline(lines []string, timeline []speechSegment) []subtitleCue {
+	// 识别结果每句的累计权重边界
+	bounds := make([]float64, len(timeline)+1)
+	for i, s := range timeline {
+		bounds[i+1] = bounds[i] + cueWeight(s.Text)
+	}
+	total := 0.0
+	for _, l := range lines {
+		total += cueWeight(l)
+	}
+	scale := bounds[len(timeline)] / total
+	// at 返回字幕累计权重 w 对应的时间；恰在句间分界时 end 为前一句结束，否则为后一句开始
+	at := func(w float64, end bool) float64 {
+		w *= scale
+		// 距句间分界不超过 2 个字时视为同一分界，字幕与识别的断句常有细微出入
+		for i := 1; i < len(timeline); i++ {
+			if math.Abs(w-bounds[i]) <= 2 {
+				if end {
+					return timeline[i-1].End
+				}
+				return timeline[i].Start
+			}
+		}
+		for i, s := range timeline {
+			if w <= bounds[i+1] || i == len(timeline)-1 {
+				frac := math.Min(math.Max((w-bounds[i])/(bounds[i+1]-bounds[i]), 0), 1)
+				return s.Start + (s.End-s.Start)*frac
+			}
+		}
+		return timeline[len(timeline)-1].End
+	}
+	cues := make([]subtitleCue, len(lines))
+	acc := 0.0
+	for i, l := range lines {
+		start := at(acc, false)
+		acc += cueWeight(l)
+		end := at(acc, true)
+		if i == 0 {
+			start = timeline[0].Start
+		}
+		if i == len(lines)-1 {
+			end = timeline[len(timeline)-1].End
+		}
+		cues[i] = subtitleCue{Start: start, End: math.Max(end, start), Text: l}
+	}
+	return cues
+}
+
+// snapToSilence 在 tolerance 秒内寻找最近的停顿中点，且不早于 after
+func snapToSilence(t float64, silences []silenceSpan, after, tolerance float64) float64 {
+	best, bestDist := t, tolerance
+	for _, s := range silences {
+		mid := (s.Start + s.End) / 2
+		if d := math.Abs(mid - t); d < bestDist && mid > after+0.2 {
+			best, bestDist = mid, d
+		}
+	}
+	return best
+}
+
+func formatCueTime(sec float64, sep string) string {
+	ms := int64(math.Round(sec * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+func renderSRT(cues []subtitleCue) string {
+	var b strings.Builder
+	for i, c := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatCueTime(c.Start, ","), formatCueTime(c.End, ","), c.Text)
+	}
+	return b.String()
+}
+
+func renderVTT(cues []subtitleCue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatCueTime(c.Start, "."), formatCueTime(c.End, "."), c.Text)
+	}
+	return b.String()
+}
+
+// escapeFilterPath 转义 ffmpeg 滤镜参数中的路径
+func escapeFilterPath(p string) string {
+	r := strings.NewReplacer(`\`, `\\`, `:`, `\:`, `'`, `\'`)
+	return r.Replace(p)
+}
+
+// burnSubtitles 把 SRT 以指定样式烧录进视频，音轨直接复制
+func burnSubtitles(ctx context.Context, src, srt, dst string, style subtitleStyle) error {
+	vf := fmt.Sprintf("subtitles=filename=%s:charenc=UTF-8:force_style='%s'", escapeFilterPath(srt), style.forceStyle(cfg.SubtitleFont))
+	args := []string{"-y", "-i", src, "-vf", vf}
+	args = append(args, x264Args...)
+	args = append(args, "-c:a", "copy", dst)
+	if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("%v | %s", err, truncateSnippet(stderr))
+	}
+	return nil
+}
+
+// subtitleText 字幕文本：优先使用请求指定的文本，其次为 TTS 文本
+func subtitleText(req AutoProcessReq) string {
+	if req.Subtitles.Text != "" {
+		return req.Subtitles.Text
+	}
+	if req.UseTTS {
+		return req.Text
+	}
+	return ""
+}
+
+// generateSubtitles 为成片生成字幕：输出同名 .srt/.vtt 到结果目录，burn 模式下原地替换成片。
+// offset 为之后合成时拼接在前面的片头时长，只平移字幕文件，烧录按正片计时。
+// 字幕失败不影响成片交付，只记录在 subtitle_error
+func generateSubtitles(ctx context.Context, status *AutoProcessStatus, req AutoProcessReq, videoPath string, offset float64) {
+	opts := req.Subtitles
+	status.CurrentStep = "生成字幕"
+	status.Progress = 97
+	status.beginStage(stageSubtitles)
+	persistTaskStatus(status)
+
+	fail := func(format string, args ...any) {
+		status.SubtitleError = fmt.Sprintf(format, args...)
+		status.endStage(stageOutcomeFailed, status.SubtitleError)
+		taskLog(ctx, status).Warn("字幕生成失败", "err", status.SubtitleError)
+	}
+
+	text := subtitleText(req)
+	if strings.TrimSpace(text) == "" {
+		fail("没有字幕文本：自带音频的任务需提供 subtitle_text")
+		return
+	}
+	lines := splitSubtitleText(text, cfg.SubtitleMaxChars)
+	if len(lines) == 0 {
+		fail("字幕文本没有可显示的内容")
+		return
+	}
+	probe, err := probeMedia(ctx, videoPath)
+	if err != nil {
+		fail("读取成片时长失败: %v", err)
+		return
+	}
+	duration := probe.duration()
+	if a := probe.stream("audio"); a != nil {
+		if d, err := strconv.ParseFloat(a.Duration, 64); err == nil && d > 0 {
+			duration = d
+		}
+	}
+	if duration <= 0 {
+		fail("成片时长未知")
+		return
+	}
+	var cues []subtitleCue
+	aligner := "asr"
+	if len(status.SpeechTimeline) > 0 {
+		cues = alignCuesToTimeline(lines, status.SpeechTimeline)
+	} else {
+		aligner = "heuristic"
+		silences, err := detectSilences(ctx, videoPath)
+		if err != nil {
+			// 检测失败时按权重均匀分配
+			taskLog(ctx, status).Warn("停顿检测失败，按字数分配字幕时长", "err", err)
+		}
+		cues = alignCues(lines, duration, silences)
+	}
+	shifted := make([]subtitleCue, len(cues))
+	for i, c := range cues {
+		shifted[i] = subtitleCue{Start: c.Start + offset, End: c.End + offset, Text: c.Text}
+	}
+
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	srtPath, vttPath := base+".srt", base+".vtt"
+	if err := os.WriteFile(srtPath, []byte(renderSRT(shifted)), 0o644); err != nil {
+		fail("写入 SRT 失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(vttPath, []byte(renderVTT(shifted)), 0o644); err != nil {
+		fail("写入 VTT 失败: %v", err)
+		return
+	}
+	status.SubtitleFiles = []string{filepath.Base(srtPath), filepath.Base(vttPath)}
+
+	if opts.Mode == subtitleModeBurn {
+		// 滤镜参数中的路径需要转义，使用任务 ID 命名的工作文件避免特殊字符
+		work := filepath.Join(cfg.WorkDir, "subtitles")
+		os.MkdirAll(work, 0o755)
+		workSRT := filepath.Join(work, status.TaskID+".srt")
+		burned := filepath.Join(work, status.TaskID+".mp4")
+		defer os.Remove(workSRT)
+		defer os.Remove(burned)
+		if err := os.WriteFile(workSRT, []byte(renderSRT(cues)), 0o644); err != nil {
+			fail("写入 SRT 失败: %v", err)
+			return
+		}
+		if err := burnSubtitles(ctx, videoPath, workSRT, burned, opts.resolvedStyle()); err != nil {
+			fail("字幕烧录失败: %v", err)
+			return
+		}
+		if err := copyFile(burned, videoPath); err != nil {
+			fail("替换成片失败: %v", err)
+			return
+		}
+		status.SubtitlesBurned = true
+	}
+	status.noteStage(fmt.Sprintf("%s, %s, %s, %d 条, %.1fs", opts.Mode, opts.Style, aligner, len(cues), duration))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSplitSubtitleText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxRunes int
+		want     []string
+	}{
+		{name: "按句末标点断句", text: "今天天气很好。我们去公园散步吧！", maxRunes: 18,
+			want: []string{"今天天气很好", "我们去公园散步吧！"}},
+		{name: "短分句合并", text: "你好，欢迎光临，请坐。", maxRunes: 18,
+			want: []string{"你好，欢迎光临，请坐"}},
+		{name: "长句按逗号切分", text: "第一句话比较长，第二句话也比较长，第三句", maxRunes: 10,
+			want: []string{"第一句话比较长", "第二句话也比较长", "第三句"}},
+		{name: "无标点长句按字数均分", text: "一二三四五六七八九十一二三四五六七八九十", maxRunes: 8,
+			want: []string{"一二三四五六", "七八九十一二三", "四五六七八九十"}},
+		{name: "换行视为断句", text: "第一行\n第二行", maxRunes: 18,
+			want: []string{"第一行", "第二行"}},
+		{name: "只有标点", text: "。。，", maxRunes: 18, want: nil},
+		{name: "空文本", text: "", maxRunes: 18, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitSubtitleText(tt.text, tt.maxRunes); !slices.Equal(got, tt.want) {
+				t.Errorf("splitSubtitleText(%q, %d) = %q，期望 %q", tt.text, tt.maxRunes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCueWeight(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"你好", 2},
+		{"hello world", 3},
+		{"AI助手", 3.5},
+		{"好的，", 2.5},
+		{"，", 1},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		if got := cueWeight(tt.text); got != tt.want {
+			t.Errorf("cueWeight(%q) = %v，期望 %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAlignCues(t *testing.T) {
+	lines := []string{"一二三", "四五六七八九"}
+	tests := []struct {
+		name     string
+		duration float64
+		silences []silenceSpan
+		want     []subtitleCue
+	}{
+		{name: "无停顿按权重分配", duration: 9,
+			want: []subtitleCue{{0, 3, "一二三"}, {3, 9, "四五六七八九"}}},
+		{name: "跳过首尾静音并吸附到停顿中点", duration: 10,
+			silences: []silenceSpan{{0, 1}, {4, 4.4}, {9.5, -1}},
+			want:     []subtitleCue{{1, 4.2, "一二三"}, {4.2, 9.5, "四五六七八九"}}},
+		{name: "停顿过远不吸附", duration: 9,
+			silences: []silenceSpan{{6, 6.4}},
+			want:     []subtitleCue{{0, 3, "一二三"}, {3, 9, "四五六七八九"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertCues(t, alignCues(lines, tt.duration, tt.silences), tt.want)
+		})
+	}
+}
+
+func TestAlignCuesToTimeline(t *testing.T) {
+	timeline := []speechSegment{
+		{Text: "今天天气很好。", Start: 0.2, End: 1.8},
+		{Text: "我们去公园散步吧。", Start: 2.3, End: 4.5},
+	}
+	tests := []struct {
+		name     string
+		lines    []string
+		timeline []speechSegment
+		want     []subtitleCue
+	}{
+		{name: "字幕与识别断句一致", lines: []string{"今天天气很好", "我们去公园散步吧"}, timeline: timeline,
+			want: []subtitleCue{{0.2, 1.8, "今天天气很好"}, {2.3, 4.5, "我们去公园散步吧"}}},
+		{name: "句内分界按权重插值", lines: []string{"今天天气很好", "我们去公园", "散步吧"}, timeline: timeline,
+			want: []subtitleCue{{0.2, 1.8, "今天天气很好"}, {2.3, 3.668, "我们去公园"}, {3.668, 4.5, "散步吧"}}},
+		{name: "单句识别结果", lines: []string{"一二三四", "五六七八"},
+			timeline: []speechSegment{{Text: "一二三四五六七八", Start: 1, End: 5}},
+			want:     []subtitleCue{{1, 3, "一二三四"}, {3, 5, "五六七八"}}},
+		{name: "识别文本与字幕有出入时仍对齐到句间停顿", lines: []string{"今天天气真好", "我们去公园散步"}, timeline: timeline,
+			want: []subtitleCue{{0.2, 1.8, "今天天气真好"}, {2.3, 4.5, "我们去公园散步"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertCues(t, alignCuesToTimeline(tt.lines, tt.timeline), tt.want)
+		})
+	}
+}
+
+func TestCleanSpeechTimeline(t *testing.T) {
+	got := cleanSpeechTimeline([]speechSegment{
+		{Text: " 第二句 ", Start: 2, End: 3},
+		{Text: "", Start: 0, End: 1},
+		{Text: "倒置", Start: 5, End: 4},
+		{Text: "负数", Start: -1, End: 1},
+		{Text: "第一句", Start: 0.5, End: 1.5},
+	})
+	want := []speechSegment{{Text: "第一句", Start: 0.5, End: 1.5}, {Text: "第二句", Start: 2, End: 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("cleanSpeechTimeline = %+v，期望 %+v", got, want)
+	}
+}
+
+func TestFormatCueTime(t *testing.T) {
+	tests := []struct {
+		sec  float64
+		sep  string
+		want string
+	}{
+		{0, ",", "00:00:00,000"},
+		{1.5, ".", "00:00:01.500"},
+		{59.9996, ",", "00:01:00,000"},
+		{3661.2345, ",", "01:01:01,235"},
+	}
+	for _, tt := range tests {
+		if got := formatCueTime(tt.sec, tt.sep); got != tt.want {
+			t.Errorf("formatCueTime(%v, %q) = %q，期望 %q", tt.sec, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestRenderSubtitles(t *testing.T) {
+	cues := []subtitleCue{{0, 1.25, "第一条"}, {1.25, 62, "第二条"}}
+	wantSRT := "1\n00:00:00,000 --> 00:00:01,250\n第一条\n\n2\n00:00:01,250 --> 00:01:02,000\n第二条\n\n"
+	if got := renderSRT(cues); got != wantSRT {
+		t.Errorf("renderSRT = %q，期望 %q", got, wantSRT)
+	}
+	wantVTT := "WEBVTT\n\n00:00:00.000 --> 00:00:01.250\n第一条\n\n00:00:01.250 --> 00:01:02.000\n第二条\n\n"
+	if got := renderVTT(cues); got != wantVTT {
+		t.Errorf("renderVTT = %q，期望 %q", got, wantVTT)
+	}
+}
+
+func assertCues(t *testing.T, got, want []subtitleCue) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("得到 %d 条字幕 %+v，期望 %d 条 %+v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i].Text != want[i].Text || math.Abs(got[i].Start-want[i].Start) > 1e-3 || math.Abs(got[i].End-want[i].End) > 1e-3 {
+			t.Errorf("第 %d 条 = %+v，期望 %+v", i, got[i], want[i])
+		}
+	}
+}
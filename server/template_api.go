@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// templateKindParam 读取路径中的 :kind，支持 audio|video 模版与 music|bumper|logo 素材
+func templateKindParam(c *gin.Context) (string, bool) {
+	kind := c.Param("kind")
+	if !slices.Contains(templateKinds, kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind 仅支持 " + strings.Join(templateKinds, "|")})
+		return "", false
+	}
+	return kind, true
+}
+
+// templateParam 读取 :kind/:name 并确认模版存在于索引中且对当前用户可见
+func templateParam(c *gin.Context) (string, TemplateItem, bool) {
+	kind, ok := templateKindParam(c)
+	if !ok {
+		return "", TemplateItem{}, false
+	}
+	name := c.Param("name")
+	item, found, err := lookupTemplateItem(kind, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", TemplateItem{}, false
+	}
+	if !found || !templateVisible(item, usernameFromContext(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("模板 %s 未找到", name)})
+		return "", TemplateItem{}, false
+	}
+	return kind, item, true
+}
+
+// templateOwnedParam 在 templateParam 基础上要求当前用户可修改该模版
+func templateOwnedParam(c *gin.Context) (string, TemplateItem, bool) {
+	kind, item, ok := templateParam(c)
+	if !ok {
+		return "", TemplateItem{}, false
+	}
+	if !templateWritable(item, usernameFromContext(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有模版上传者可以修改"})
+		return "", TemplateItem{}, false
+	}
+	return kind, item, true
+}
+
+// templateAttrsFromForm 读取上传表单中的 tags、description、folder、shared 与视频模版的 chaofen、watermark_switch、pn，
+// 未提交的字段不修改
+func templateAttrsFromForm(c *gin.Context) (templateAttrs, error) {
+	var attrs templateAttrs
+	if v, ok := c.GetPostForm("tags"); ok {
+		tags := splitTemplateTags(v)
+		attrs.Tags = &tags
+	}
+	if v, ok := c.GetPostForm("description"); ok {
+		attrs.Description = &v
+	}
+	if v, ok := c.GetPostForm("folder"); ok {
+		attrs.Folder = &v
+	}
+	if v, ok := c.GetPostForm("shared"); ok {
+		shared := parseBool(v)
+		attrs.Shared = &shared
+	}
+	render, err := renderPresetFromForm(c)
+	if err != nil {
+		return attrs, err
+	}
+	attrs.Render = render
+	return attrs, nil
+}
+
+// GET /api/templates?kind=audio|video|music|bumper|logo&q=&tag=&folder=&owner=&mine=1&sort=updated_at|name|duration&order=desc|asc&page=1&page_size=50
+// 未传 page_size 时返回全部匹配项；未传 kind 时音频、视频模版两类分别筛选与分页
+func handleTemplateList(c *gin.Context) {
+	kind := strings.TrimSpace(c.Query("kind"))
+	if kind != "" && !slices.Contains(templateKinds, kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind 仅支持 " + strings.Join(templateKinds, "|")})
+		return
+	}
+
+	q := templateQuery{
+		User:    usernameFromContext(c),
+		Keyword: strings.TrimSpace(c.Query("q")),
+		Tag:     strings.TrimSpace(c.Query("tag")),
+		Owner:   strings.TrimSpace(c.Query("owner")),
+		Sort:    c.DefaultQuery("sort", "updated_at"),
+		Desc:    c.DefaultQuery("order", "desc") == "desc",
+		Page:    1,
+	}
+	if parseBool(c.Query("mine")) {
+		if q.User == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "请先登录"})
+			return
+		}
+		q.Owner = q.User
+	}
+	folder, err := normalizeTemplateFolder(c.Query("folder"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.Folder = folder
+	switch q.Sort {
+	case "updated_at", "name", "duration":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort 仅支持 updated_at|name|duration"})
+		return
+	}
+	if v := c.Query("page"); v != "" {
+		if q.Page, err = strconv.Atoi(v); err != nil || q.Page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page 须为正整数"})
+			return
+		}
+	}
+	if v := c.Query("page_size"); v != "" {
+		if q.PageSize, err = strconv.Atoi(v); err != nil || q.PageSize < 1 || q.PageSize > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page_size 取值 1-200"})
+			return
+		}
+	}
+
+	kinds := []string{kind}
+	if kind == "" {
+		kinds = []string{templateKindAudio, templateKindVideo}
+	}
+	resp := gin.H{"page": q.Page, "page_size": q.PageSize}
+	for _, k := range kinds {
+		items, total, folders, err := queryTemplates(k, q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp[k] = items
+		resp[k+"_total"] = total
+		resp[k+"_folders"] = folders
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /api/templates/:kind/:name: 删除模版文件、产物与索引；有排队或执行中的任务引用时拒绝
+func handleTemplateDelete(c *gin.Context) {
+	kind, item, ok := templateOwnedParam(c)
+	if !ok {
+		return
+	}
+	refs, err := templateReferences(kind, item.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("检查模版引用失败: %v", err)})
+		return
+	}
+	if len(refs) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "模版正被排队或执行中的任务使用，暂不能删除", "tasks": refs})
+		return
+	}
+	if err := removeTemplateItem(kind, item.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版信息保存失败: %v", err)})
+		return
+	}
+	if err := removeTemplateFiles(kind, item.Name); err != nil {
+		loggerFrom(c.Request.Context()).Warn("删除模版文件失败", "template", item.Name, "err", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "模版已删除", "name": item.Name})
+}
+
+// PATCH /api/templates/:kind/:name: 修改显示名称、标签、描述、分组与共享状态，模版标识不变
+func handleTemplateUpdate(c *gin.Context) {
+	kind, item, ok := templateOwnedParam(c)
+	if !ok {
+		return
+	}
+	var attrs templateAttrs
+	if err := c.ShouldBindJSON(&attrs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	item.Kind = kind
+	if err := applyTemplateAttrs(&item, attrs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 渲染参数随版本记录：修改时同步到当前版本，回滚与固定版本的任务沿用各自版本的参数
+	if attrs.Render != nil {
+		versions, err := templateVersionsOf(kind, item)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range versions {
+			if versions[i].Version == item.Version {
+				versions[i].Render = item.Render
+			}
+		}
+		if err := saveTemplateVersions(kind, item.Name, versions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版版本保存失败: %v", err)})
+			return
+		}
+	}
+	item.UpdatedAt = time.Now().Unix()
+	if err := upsertTemplateItem(kind, item); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版信息保存失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "模版已更新", "template": item})
+}
+
+// PUT /api/templates/:kind/:name: 替换模版媒体，保留标识与其余属性
+func handleTemplateReplace(c *gin.Context) {
+	kind, item, ok := templateOwnedParam(c)
+	if !ok {
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少模版文件 file"})
+		return
+	}
+	saveTemplateUpload(c, kind, item, file)
+}
+
+// GET /api/templates/:kind/:name/file: 预览或下载模版，支持 Range；version=N 指定版本（默认当前版本），
+// variant=derived 返回流水线产物（规范化音频 / 静音视频），download=1 以附件下载
+func handleTemplateFile(c *gin.Context) {
+	kind, item, ok := templateParam(c)
+	if !ok {
+		return
+	}
+	item, ok = templateVersionQuery(c, kind, item)
+	if !ok {
+		return
+	}
+	var path string
+	switch c.DefaultQuery("variant", "original") {
+	case "original":
+		path = templateMediaPath(kind, item.Name, item.Version, "")
+	case "derived":
+		p, ok := templatePipelineInput(kind, item)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "模版没有预处理产物"})
+			return
+		}
+		path = p
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "variant 仅支持 original|derived"})
+		return
+	}
+	if st, err := os.Stat(path); err != nil || st.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+	if parseBool(c.Query("download")) {
+		c.FileAttachment(path, item.Name+templateFileExt(kind))
+	} else {
+		// http.ServeFile 处理 Range / If-Modified-Since，浏览器可直接拖动播放
+		c.File(path)
+	}
+	addBytesTransferred("download", int64(c.Writer.Size()))
+}
+
+// GET /api/templates/:kind/:name/thumbnail?version=N: 视频模版与片头片尾的封面，旧模版没有封面时现场生成；台标返回图片本身
+func handleTemplateThumbnail(c *gin.Context) {
+	kind, item, ok := templateParam(c)
+	if !ok {
+		return
+	}
+	if kind != templateKindVideo && kind != templateKindBumper && kind != templateKindLogo {
+		c.JSON(http.StatusNotFound, gin.H{"error": "音频类模版没有缩略图"})
+		return
+	}
+	item, ok = templateVersionQuery(c, kind, item)
+	if !ok {
+		return
+	}
+	if kind == templateKindLogo {
+		c.Header("Cache-Control", "max-age=300")
+		c.File(templateMediaPath(kind, item.Name, item.Version, ""))
+		return
+	}
+	thumb := templateMediaPath(kind, item.Name, item.Version, derivedThumbnail)
+	if _, err := os.Stat(thumb); err != nil {
+		err := os.MkdirAll(filepath.Dir(thumb), 0o755)
+		if err == nil {
+			err = buildTemplateThumbnail(c.Request.Context(), templateMediaPath(kind, item.Name, item.Version, ""), thumb)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.Header("Cache-Control", "max-age=300")
+	c.File(thumb)
+}
+
+// templateVersionQuery 读取 ?version=N 并返回指向该版本的模版视图，未指定时为当前版本
+func templateVersionQuery(c *gin.Context, kind string, item TemplateItem) (TemplateItem, bool) {
+	raw := c.Query("version")
+	if raw == "" {
+		return item, true
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version 须为整数"})
+		return item, false
+	}
+	versions, err := templateVersionsOf(kind, item)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return item, false
+	}
+	v, found := findTemplateVersion(versions, version)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("模板 %s 不存在版本 %d", item.Name, version)})
+		return item, false
+	}
+	return withTemplateVersion(item, v), true
+}
+
+// GET /api/templates/:kind/:name/versions: 模版全部版本与当前版本
+func handleTemplateVersions(c *gin.Context) {
+	kind, item, ok := templateParam(c)
+	if !ok {
+		return
+	}
+	versions, err := templateVersionsOf(kind, item)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": item.Name, "current": item.Version, "versions": versions})
+}
+
+// GET /api/templates/:kind/:name/versions/diff?from=1&to=2: 比较两个版本的来源文件与媒体信息，to 缺省为当前版本
+func handleTemplateVersionDiff(c *gin.Context) {
+	kind, item, ok := templateParam(c)
+	if !ok {
+		return
+	}
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from 须为版本号"})
+		return
+	}
+	to := item.Version
+	if raw := c.Query("to"); raw != "" {
+		if to, err = strconv.Atoi(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to 须为版本号"})
+			return
+		}
+	}
+	versions, err := templateVersionsOf(kind, item)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a, okA := findTemplateVersion(versions, from)
+	b, okB := findTemplateVersion(versions, to)
+	if !okA || !okB {
+		c.JSON(http.StatusNotFound, gin.H{"error": "指定的版本不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "changes": diffTemplateVersions(a, b)})
+}
+
+// POST /api/templates/:kind/:name/rollback {"version": N}: 把当前版本指回历史版本，不删除任何版本
+func handleTemplateRollback(c *gin.Context) {
+	kind, item, ok := templateOwnedParam(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Version *int `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Version == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 version"})
+		return
+	}
+	versions, err := templateVersionsOf(kind, item)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	v, found := findTemplateVersion(versions, *body.Version)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("模板 %s 不存在版本 %d", item.Name, *body.Version)})
+		return
+	}
+	if _, err := os.Stat(templateMediaPath(kind, item.Name, v.Version, "")); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("版本 %d 的文件缺失，无法回滚", v.Version)})
+		return
+	}
+	// 旧模版首次回滚前补记版本 0，保证之后仍可切回
+	if err := saveTemplateVersions(kind, item.Name, versions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版版本保存失败: %v", err)})
+		return
+	}
+	previous := item.Version
+	item = withTemplateVersion(item, v)
+	item.UpdatedAt = time.Now().Unix()
+	if err := upsertTemplateItem(kind, item); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("模版信息保存失败: %v", err)})
+		return
+	}
+	loggerFrom(c.Request.Context()).Info("模版已回滚", "kind", kind, "template", item.Name, "from", previous, "to", v.Version)
+	c.JSON(http.StatusOK, gin.H{"message": "模版已回滚", "template": item})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -16,14 +17,23 @@ import (
 const (
 	templateKindAudio = "audio"
 	templateKindVideo = "video"
+	// 成片合成素材库：背景音乐、片头片尾、台标，与模版共用存储、版本与权限管理
+	templateKindMusic  = "music"
+	templateKindBumper = "bumper"
+	templateKindLogo   = "logo"
 )
 
+// templateKinds 全部模版与素材类型
+var templateKinds = []string{templateKindAudio, templateKindVideo, templateKindMusic, templateKindBumper, templateKindLogo}
+
 func templateKindDir(kind string) string {
 	switch kind {
 	case templateKindAudio:
 		return cfg.AudioTemplateDir
 	case templateKindVideo:
 		return cfg.VideoTemplateDir
+	case templateKindMusic, templateKindBumper, templateKindLogo:
+		return filepath.Join(cfg.AssetDir, kind)
 	default:
 		return filepath.Join(cfg.WorkDir, "templates", kind)
 	}
@@ -41,8 +51,12 @@ func templateFileExt(kind string) string {
 	switch kind {
 	case templateKindAudio:
 		return ".wav"
-	case templateKindVideo:
+	case templateKindVideo, templateKindBumper:
 		return ".mp4"
+	case templateKindMusic:
+		return ".m4a"
+	case templateKindLogo:
+		return ".png"
 	default:
 		return ""
 	}
@@ -145,6 +159,63 @@ func upsertTemplateItem(kind string, item TemplateItem) error {
 	return saveTemplateList(kind, items)
 }
 
+// lookupTemplateItem 只查索引，不检查文件
+func lookupTemplateItem(kind, name string) (TemplateItem, bool, error) {
+	items, err := loadTemplateList(kind)
+	if err != nil {
+		return TemplateItem{}, false, err
+	}
+	for _, it := range items {
+		if it.Name == name {
+			return it, true, nil
+		}
+	}
+	return TemplateItem{}, false, nil
+}
+
+func removeTemplateItem(kind, name string) error {
+	items, err := loadTemplateList(kind)
+	if err != nil {
+		return err
+	}
+	kept := items[:0]
+	for _, it := range items {
+		if it.Name != name {
+			kept = append(kept, it)
+		}
+	}
+	return saveTemplateList(kind, kept)
+}
+
+// templateReferences 返回仍在排队或执行中、引用该模版的任务 ID
+func templateReferences(kind, name string) ([]string, error) {
+	statuses, err := listTaskStatuses()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, st := range statuses {
+		if st.Request == nil || (st.Status != "queued" && st.Status != "processing") {
+			continue
+		}
+		var refs []string
+		switch kind {
+		case templateKindAudio:
+			refs = []string{st.Request.AudioTemplateName}
+		case templateKindVideo:
+			refs = []string{st.Request.VideoTemplateName}
+		default:
+			if o := st.Request.Compose; o != nil {
+				refs = o.assets(kind)
+			}
+		}
+		if slices.Contains(refs, name) {
+			ids = append(ids, st.TaskID)
+		}
+	}
+	return ids, nil
+}
+
 func findTemplateItem(kind, name string) (TemplateItem, string, error) {
 	var empty TemplateItem
 	items, err := loadTemplateList(kind)
@@ -157,6 +228,13 @@ func findTemplateItem(kind, name string) (TemplateItem, string, error) {
 			if err != nil {
 				return empty, "", err
 			}
+			if it.Version > 0 {
+				path = templateMediaPath(kind, it.Name, it.Version, "")
+				if _, err := os.Stat(path); err != nil {
+					return empty, "", fmt.Errorf("模板 %s 版本 %d 文件缺失", name, it.Version)
+				}
+				return it, path, nil
+			}
 			if _, err := os.Stat(path); err != nil {
 				if os.IsNotExist(err) {
 					legacyDir := filepath.Join(cfg.WorkDir, "templates", kind)
@@ -188,3 +266,541 @@ func listTemplates(kind string) ([]TemplateItem, error) {
 	}
 	return items, nil
 }
+
+// 模版上传时生成的流水线就绪产物，存放在模版目录的 derived 子目录，文件名为 模版标识+后缀
+const (
+	derivedNormAudio   = ".norm.wav"   // 音频模版：按模版处理链（默认响度归一）转换的 16kHz 单声道 WAV
+	derivedSilentVideo = ".silent.mp4" // 视频模版：去除音轨
+	derivedThumbnail   = ".jpg"        // 视频模版：缩略图
+)
+
+func templateDerivedPath(kind, name, suffix string) string {
+	return filepath.Join(templateKindDir(kind), "derived", name+suffix)
+}
+
+// templateVersionDir 模版各版本文件所在目录：versions/<模版标识>/
+func templateVersionDir(kind, name string) string {
+	return filepath.Join(templateKindDir(kind), "versions", name)
+}
+
+// templateMediaPath 返回模版某个版本的文件：suffix 为空时是转换后的媒体，否则为对应产物。
+// 版本 0 为引入版本管理之前上传的模版，沿用平铺路径
+func templateMediaPath(kind, name string, version int, suffix string) string {
+	if version == 0 {
+		if suffix == "" {
+			return filepath.Join(templateKindDir(kind), name+templateFileExt(kind))
+		}
+		return templateDerivedPath(kind, name, suffix)
+	}
+	if suffix == "" {
+		suffix = templateFileExt(kind)
+	}
+	return filepath.Join(templateVersionDir(kind, name), fmt.Sprintf("v%d%s", version, suffix))
+}
+
+// buildTemplateDerivatives 由已转换的模版文件生成 item.Version 的产物，成功后标记 item.Prepared
+func buildTemplateDerivatives(ctx context.Context, kind, src string, item *TemplateItem) error {
+	dst := func(suffix string) string { return templateMediaPath(kind, item.Name, item.Version, suffix) }
+	if err := os.MkdirAll(filepath.Dir(dst(derivedThumbnail)), 0o755); err != nil {
+		return err
+	}
+	switch kind {
+	case templateKindAudio:
+		chain, err := parseAudioChain(item.AudioFilters)
+		if err != nil {
+			return err
+		}
+		if err := convertReferenceAudio(ctx, src, dst(derivedNormAudio), chain); err != nil {
+			return fmt.Errorf("音频预处理失败: %w", err)
+		}
+	case templateKindVideo:
+		_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", src, "-an", "-c:v", "copy", dst(derivedSilentVideo))
+		if err != nil {
+			return fmt.Errorf("生成静音视频失败: %v | %s", err, stderr)
+		}
+		if err := buildTemplateThumbnail(ctx, src, dst(derivedThumbnail)); err != nil {
+			return err
+		}
+	case templateKindBumper:
+		if err := buildTemplateThumbnail(ctx, src, dst(derivedThumbnail)); err != nil {
+			return err
+		}
+	case templateKindMusic, templateKindLogo:
+		// 合成时直接使用上传时转换好的文件
+	default:
+		return fmt.Errorf("unsupported template kind: %s", kind)
+	}
+	item.Prepared = true
+	return nil
+}
+
+// buildTemplateThumbnail 从视频中挑选代表帧（thumbnail 滤镜）生成 320 宽的封面
+func buildTemplateThumbnail(ctx context.Context, src, dst string) error {
+	_, stderr, err := run(ctx, "ffmpeg", "-y", "-i", src, "-vf", "thumbnail,scale=320:-2", "-frames:v", "1", dst)
+	if err != nil {
+		return fmt.Errorf("生成缩略图失败: %v | %s", err, stderr)
+	}
+	return nil
+}
+
+// removeTemplateDerivatives 删除模版某个版本的全部产物（不存在时忽略）
+func removeTemplateDerivatives(kind, name string, version int) {
+	for _, suffix := range []string{derivedNormAudio, derivedSilentVideo, derivedThumbnail} {
+		os.Remove(templateMediaPath(kind, name, version, suffix))
+	}
+}
+
+// removeTemplateFiles 删除模版全部版本的媒体、产物与版本记录
+func removeTemplateFiles(kind, name string) error {
+	os.Remove(templateMediaPath(kind, name, 0, ""))
+	removeTemplateDerivatives(kind, name, 0)
+	if err := os.RemoveAll(templateVersionDir(kind, name)); err != nil {
+		return err
+	}
+	if redisClient == nil {
+		return fmt.Errorf("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return redisClient.Del(ctx, templateVersionsKey(kind, name), templateVersionSeqKey(kind, name)).Err()
+}
+
+// templatePipelineInput 返回流水线可直接使用的模版产物；旧模版没有产物时返回 false，由流水线现场转换
+func templatePipelineInput(kind string, item TemplateItem) (string, bool) {
+	if !item.Prepared {
+		return "", false
+	}
+	suffix := derivedNormAudio
+	if kind == templateKindVideo {
+		suffix = derivedSilentVideo
+	}
+	path := templateMediaPath(kind, item.Name, item.Version, suffix)
+	if st, err := os.Stat(path); err != nil || st.IsDir() {
+		return "", false
+	}
+	return path, true
+}
+
+// templateAttrs 可由上传表单或 PATCH 修改的模版属性，nil 表示不修改
+type templateAttrs struct {
+	DisplayName *string   `json:"display_name"`
+	Tags        *[]string `json:"tags"`
+	Description *string   `json:"description"`
+	Folder      *string   `json:"folder"`
+	Shared      *bool     `json:"shared"`
+	// 视频模版的渲染参数，空对象表示清除
+	Render *RenderPreset `json:"render"`
+}
+
+const (
+	maxTemplateTags        = 20
+	maxTemplateTagLen      = 32
+	maxTemplateFolderLen   = 128
+	maxTemplateDescription = 500
+)
+
+// splitTemplateTags 把 "a,b，c" 形式的表单值拆成标签
+func splitTemplateTags(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '，' || r == '、' })
+}
+
+// normalizeTemplateTags 去空白、按大小写不敏感去重并校验数量与长度
+func normalizeTemplateTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		if len([]rune(t)) > maxTemplateTagLen {
+			return nil, fmt.Errorf("标签过长: %s", t)
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTemplateTags {
+		return nil, fmt.Errorf("标签最多 %d 个", maxTemplateTags)
+	}
+	return out, nil
+}
+
+// normalizeTemplateFolder 规整分组路径：去掉首尾与重复的 /
+func normalizeTemplateFolder(raw string) (string, error) {
+	var parts []string
+	for _, p := range strings.Split(raw, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	folder := strings.Join(parts, "/")
+	if len([]rune(folder)) > maxTemplateFolderLen {
+		return "", fmt.Errorf("分组路径过长")
+	}
+	return folder, nil
+}
+
+func applyTemplateAttrs(item *TemplateItem, attrs templateAttrs) error {
+	if attrs.DisplayName != nil {
+		name := strings.TrimSpace(*attrs.DisplayName)
+		if name == "" {
+			return fmt.Errorf("display_name 不能为空")
+		}
+		item.DisplayName = name
+	}
+	if attrs.Tags != nil {
+		tags, err := normalizeTemplateTags(*attrs.Tags)
+		if err != nil {
+			return err
+		}
+		item.Tags = tags
+	}
+	if attrs.Description != nil {
+		desc := strings.TrimSpace(*attrs.Description)
+		if len([]rune(desc)) > maxTemplateDescription {
+			return fmt.Errorf("描述最多 %d 字", maxTemplateDescription)
+		}
+		item.Description = desc
+	}
+	if attrs.Folder != nil {
+		folder, err := normalizeTemplateFolder(*attrs.Folder)
+		if err != nil {
+			return err
+		}
+		item.Folder = folder
+	}
+	if attrs.Shared != nil {
+		item.Shared = *attrs.Shared
+	}
+	if attrs.Render != nil {
+		if item.Kind != templateKindVideo {
+			return fmt.Errorf("只有视频模版可以设置渲染参数")
+		}
+		if err := attrs.Render.validate(); err != nil {
+			return err
+		}
+		item.Render = attrs.Render
+		if item.Render.empty() {
+			item.Render = nil
+		}
+	}
+	return nil
+}
+
+// templateVisible 公共模版、旧模版（无归属）与本人的私有模版可见
+func templateVisible(item TemplateItem, user string) bool {
+	return item.Owner == "" || item.Shared || item.Owner == user
+}
+
+// templateWritable 只有上传者可以修改、替换或删除；旧模版不限
+func templateWritable(item TemplateItem, user string) bool {
+	return item.Owner == "" || item.Owner == user
+}
+
+// templateQuery 模版列表的筛选、排序与分页条件
+type templateQuery struct {
+	User     string // 当前登录用户，决定私有模版的可见性
+	Keyword  string // 匹配标识、显示名称、描述与标签
+	Tag      string
+	Folder   string // 包含子分组
+	Owner    string
+	Sort     string // updated_at|name|duration
+	Desc     bool
+	Page     int
+	PageSize int // 0 表示不分页
+}
+
+func (q templateQuery) match(it TemplateItem) bool {
+	if !templateVisible(it, q.User) {
+		return false
+	}
+	if q.Owner != "" && it.Owner != q.Owner {
+		return false
+	}
+	if q.Folder != "" && it.Folder != q.Folder && !strings.HasPrefix(it.Folder, q.Folder+"/") {
+		return false
+	}
+	if q.Tag != "" && !slices.ContainsFunc(it.Tags, func(t string) bool { return strings.EqualFold(t, q.Tag) }) {
+		return false
+	}
+	if q.Keyword == "" {
+		return true
+	}
+	kw := strings.ToLower(q.Keyword)
+	for _, field := range append([]string{it.Name, it.DisplayName, it.Description}, it.Tags...) {
+		if strings.Contains(strings.ToLower(field), kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryTemplates 返回当前页、筛选后的总数以及可见模版的全部分组（供前端构建分组树）
+func queryTemplates(kind string, q templateQuery) ([]TemplateItem, int, []string, error) {
+	items, err := listTemplates(kind)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	folders := []string{}
+	matched := make([]TemplateItem, 0, len(items))
+	for _, it := range items {
+		if templateVisible(it, q.User) && it.Folder != "" && !slices.Contains(folders, it.Folder) {
+			folders = append(folders, it.Folder)
+		}
+		if q.match(it) {
+			matched = append(matched, it)
+		}
+	}
+	sort.Strings(folders)
+	less := func(a, b TemplateItem) bool { return a.UpdatedAt < b.UpdatedAt }
+	switch q.Sort {
+	case "name":
+		less = func(a, b TemplateItem) bool { return a.DisplayName < b.DisplayName }
+	case "duration":
+		less = func(a, b TemplateItem) bool { return templateDuration(a) < templateDuration(b) }
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if q.Desc {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+	total := len(matched)
+	if q.PageSize > 0 {
+		start := min((q.Page-1)*q.PageSize, total)
+		matched = matched[start:min(start+q.PageSize, total)]
+	}
+	return matched, total, folders, nil
+}
+
+func templateDuration(it TemplateItem) float64 {
+	if it.Meta == nil {
+		return 0
+	}
+	return it.Meta.Duration
+}
+
+// 版本记录：每次上传生成不可变的新版本，模版条目的 Version 指向当前版本
+func templateVersionsKey(kind, name string) string {
+	return templateRedisKey(kind) + ":versions:" + name
+}
+
+// loadTemplateVersions 按版本号升序返回模版的全部版本
+func loadTemplateVersions(kind, name string) ([]TemplateVersion, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	data, err := redisClient.Get(ctx, templateVersionsKey(kind, name)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return []TemplateVersion{}, nil
+		}
+		return nil, err
+	}
+	var versions []TemplateVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func saveTemplateVersions(kind, name string, versions []TemplateVersion) error {
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	if redisClient == nil {
+		return fmt.Errorf("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return redisClient.Set(ctx, templateVersionsKey(kind, name), data, 0).Err()
+}
+
+func templateVersionSeqKey(kind, name string) string {
+	return templateRedisKey(kind) + ":version_seq:" + name
+}
+
+// reserveTemplateVersion 原子地分配新版本号，并发上传不会拿到相同版本；
+// floor 为已有的最大版本，计数器落后（如引入计数器之前的模版）时跳到 floor 之后
+func reserveTemplateVersion(kind, name string, floor int) (int, error) {
+	if redisClient == nil {
+		return 0, fmt.Errorf("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key := templateVersionSeqKey(kind, name)
+	v, err := redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if v <= int64(floor) {
+		// 每次 INCRBY 的返回值互不相同，并发补齐时仍然唯一
+		if v, err = redisClient.IncrBy(ctx, key, int64(floor)-v+1).Result(); err != nil {
+			return 0, err
+		}
+	}
+	return int(v), nil
+}
+
+// appendTemplateVersion 以乐观锁追加一条版本记录，并发上传不会丢失彼此的记录；
+// legacy 非空且尚无记录时一并补上旧模版的版本 0
+func appendTemplateVersion(kind, name string, v TemplateVersion, legacy *TemplateVersion) error {
+	if redisClient == nil {
+		return fmt.Errorf("Redis 未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key := templateVersionsKey(kind, name)
+	update := func(tx *redis.Tx) error {
+		var versions []TemplateVersion
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &versions); err != nil {
+				return err
+			}
+		}
+		if len(versions) == 0 && legacy != nil {
+			versions = append(versions, *legacy)
+		}
+		versions = append(versions, v)
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		if data, err = json.Marshal(versions); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < 10; i++ {
+		if err := redisClient.Watch(ctx, update, key); err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("版本记录并发更新冲突，请重试")
+}
+
+// legacyTemplateVersion 把引入版本管理之前上传的模版记为版本 0，便于回滚
+func legacyTemplateVersion(item TemplateItem) TemplateVersion {
+	return TemplateVersion{
+		Version:      0,
+		OriginalName: item.OriginalName,
+		CreatedAt:    item.UpdatedAt,
+		CreatedBy:    item.Owner,
+		Prepared:     item.Prepared,
+		Meta:         item.Meta,
+		AudioFilters: item.AudioFilters,
+		Render:       item.Render,
+	}
+}
+
+// templateVersionsOf 返回模版的版本列表；旧模版尚无记录时补上版本 0
+func templateVersionsOf(kind string, item TemplateItem) ([]TemplateVersion, error) {
+	versions, err := loadTemplateVersions(kind, item.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 && item.Version == 0 {
+		versions = append(versions, legacyTemplateVersion(item))
+	}
+	return versions, nil
+}
+
+func findTemplateVersion(versions []TemplateVersion, version int) (TemplateVersion, bool) {
+	for _, v := range versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return TemplateVersion{}, false
+}
+
+// withTemplateVersion 返回指向指定版本的模版视图（媒体信息随版本切换）
+func withTemplateVersion(item TemplateItem, v TemplateVersion) TemplateItem {
+	item.Version = v.Version
+	item.OriginalName = v.OriginalName
+	item.Prepared = v.Prepared
+	item.Meta = v.Meta
+	item.AudioFilters = v.AudioFilters
+	item.Render = v.Render
+	return item
+}
+
+// pinTemplateVersion 供任务固定使用模版的某个版本，返回该版本的条目与媒体路径
+func pinTemplateVersion(kind string, item TemplateItem, version int) (TemplateItem, string, error) {
+	versions, err := templateVersionsOf(kind, item)
+	if err != nil {
+		return TemplateItem{}, "", err
+	}
+	v, ok := findTemplateVersion(versions, version)
+	if !ok {
+		return TemplateItem{}, "", fmt.Errorf("模板 %s 不存在版本 %d", item.Name, version)
+	}
+	pinned := withTemplateVersion(item, v)
+	path := templateMediaPath(kind, item.Name, version, "")
+	if _, err := os.Stat(path); err != nil {
+		return TemplateItem{}, "", fmt.Errorf("模板 %s 版本 %d 文件缺失", item.Name, version)
+	}
+	return pinned, path, nil
+}
+
+// templateFieldDiff 两个版本之间不同的字段
+type templateFieldDiff struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// diffTemplateVersions 逐字段比较两个版本的来源文件与媒体信息
+func diffTemplateVersions(a, b TemplateVersion) []templateFieldDiff {
+	// 嵌套结构按 "前缀.字段" 展开
+	expand := func(out map[string]any, prefix string, v any) {
+		var fields map[string]any
+		data, _ := json.Marshal(v)
+		json.Unmarshal(data, &fields)
+		for k, val := range fields {
+			out[prefix+"."+k] = val
+		}
+	}
+	flatten := func(v TemplateVersion) map[string]any {
+		out := map[string]any{
+			"original_name": v.OriginalName,
+			"created_by":    v.CreatedBy,
+			"prepared":      v.Prepared,
+			"audio_filters": v.AudioFilters,
+		}
+		if v.Meta != nil {
+			expand(out, "meta", v.Meta)
+		}
+		if v.Render != nil {
+			expand(out, "render", v.Render)
+		}
+		return out
+	}
+	from, to := flatten(a), flatten(b)
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	diffs := []templateFieldDiff{}
+	for _, k := range keys {
+		if fmt.Sprint(from[k]) != fmt.Sprint(to[k]) {
+			diffs = append(diffs, templateFieldDiff{Field: k, From: from[k], To: to[k]})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTemplateTags(t *testing.T) {
+	many := make([]string, maxTemplateTags+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("t%d", i)
+	}
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "空", tags: nil, want: []string{}},
+		{name: "去空白与空项", tags: []string{" 女声 ", "", "  "}, want: []string{"女声"}},
+		{name: "大小写不敏感去重并保留首次写法", tags: []string{"News", "news", "NEWS", "客服"}, want: []string{"News", "客服"}},
+		{name: "按字符计长度", tags: []string{strings.Repeat("长", maxTemplateTagLen)}, want: []string{strings.Repeat("长", maxTemplateTagLen)}},
+		{name: "标签过长", tags: []string{strings.Repeat("长", maxTemplateTagLen+1)}, wantErr: true},
+		{name: "数量上限", tags: many[:maxTemplateTags], want: many[:maxTemplateTags]},
+		{name: "超过数量上限", tags: many, wantErr: true},
+		{name: "去重后不超过上限", tags: append(append([]string{}, many[:maxTemplateTags]...), "T0"), want: many[:maxTemplateTags]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTemplateTags(tt.tags)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望出错，得到 %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("得到 %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTemplateTags(t *testing.T) {
+	got := splitTemplateTags("a,b，c、d,,")
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitTemplateTags = %q，期望 %q", got, want)
+	}
+}
+
+func TestNormalizeTemplateFolder(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "", want: ""},
+		{raw: "/客服//女声/", want: "客服/女声"},
+		{raw: " 营销 / 短视频 ", want: "营销/短视频"},
+		{raw: strings.Repeat("a", maxTemplateFolderLen+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeTemplateFolder(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeTemplateFolder(%q) = %q, %v，期望 %q（出错=%v）", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDiffTemplateVersions(t *testing.T) {
+	one, two := 1, 2
+	base := TemplateVersion{
+		Version:      1,
+		OriginalName: "a.mp4",
+		CreatedAt:    100,
+		CreatedBy:    "alice",
+		Meta:         &TemplateMeta{Duration: 10, Width: 1080, Height: 1920},
+	}
+	tests := []struct {
+		name   string
+		b      TemplateVersion
+		fields []string
+	}{
+		{name: "版本号与创建时间不参与比较", b: TemplateVersion{Version: 2, OriginalName: "a.mp4", CreatedAt: 200, CreatedBy: "alice",
+			Meta: &TemplateMeta{Duration: 10, Width: 1080, Height: 1920}}, fields: []string{}},
+		{name: "来源文件与媒体信息", b: TemplateVersion{OriginalName: "b.mp4", CreatedBy: "alice",
+			Meta: &TemplateMeta{Duration: 12, Width: 1080, Height: 1920}}, fields: []string{"meta.duration", "original_name"}},
+		{name: "新增的嵌套字段", b: TemplateVersion{OriginalName: "a.mp4", CreatedBy: "alice", Prepared: true,
+			Meta:   &TemplateMeta{Duration: 10, Width: 1080, Height: 1920},
+			Render: &RenderPreset{Chaofen: &one}}, fields: []string{"prepared", "render.chaofen"}},
+		{name: "缺少媒体信息", b: TemplateVersion{OriginalName: "a.mp4", CreatedBy: "alice"},
+			fields: []string{"meta.duration", "meta.format", "meta.height", "meta.size_bytes", "meta.codec", "meta.width"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := diffTemplateVersions(base, tt.b)
+			got := make([]string, len(diffs))
+			for i, d := range diffs {
+				got[i] = d.Field
+			}
+			want := append([]string{}, tt.fields...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("不同字段 %q，期望 %q", got, want)
+			}
+		})
+	}
+
+	// 差异记录两侧取值
+	a := TemplateVersion{Render: &RenderPreset{Chaofen: &one}}
+	b := TemplateVersion{Render: &RenderPreset{Chaofen: &two}}
+	diffs := diffTemplateVersions(a, b)
+	if len(diffs) != 1 || diffs[0].Field != "render.chaofen" || fmt.Sprint(diffs[0].From) != "1" || fmt.Sprint(diffs[0].To) != "2" {
+		t.Errorf("diffTemplateVersions = %+v", diffs)
+	}
+}
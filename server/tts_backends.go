@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TTSBackend 一个 TTS 服务实例；各实例需挂载同一个 HOST_VOICE_DIR
+type TTSBackend struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+}
+
+const (
+	ttsBalanceRoundRobin    = "round_robin"
+	ttsBalanceLeastInflight = "least_inflight"
+)
+
+// parseTTSBackends 读取 TTS_BACKENDS（name=url 逗号分隔，name 可省略）；未配置时使用 TTS_BASE_URL
+func parseTTSBackends(c *Config) ([]TTSBackend, error) {
+	raw := getenv("TTS_BACKENDS", "")
+	if raw == "" {
+		return []TTSBackend{{Name: "default", BaseURL: c.TTSBaseURL}}, nil
+	}
+	var list []TTSBackend
+	seen := map[string]bool{}
+	for i, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, base, ok := strings.Cut(item, "=")
+		if !ok {
+			name, base = fmt.Sprintf("tts%d", i+1), item
+		}
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("TTS_BACKENDS 名称重复: %s", name)
+		}
+		seen[name] = true
+		list = append(list, TTSBackend{Name: name, BaseURL: strings.TrimRight(strings.TrimSpace(base), "/")})
+	}
+	if len(list) == 0 {
+		return nil, errors.New("TTS_BACKENDS 未包含任何实例")
+	}
+	return list, nil
+}
+
+// ttsBackendState 进程内的实例状态：并发请求数与最近一次健康判断
+type ttsBackendState struct {
+	inflight atomic.Int64
+	mu       sync.Mutex
+	lastErr  error
+	downAt   time.Time
+}
+
+var (
+	ttsStates   sync.Map // name -> *ttsBackendState
+	ttsRRCursor atomic.Uint64
+)
+
+func ttsState(name string) *ttsBackendState {
+	v, _ := ttsStates.LoadOrStore(name, &ttsBackendState{})
+	return v.(*ttsBackendState)
+}
+
+func (s *ttsBackendState) healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr == nil
+}
+
+func (s *ttsBackendState) markDown(name string, err error) {
+	s.mu.Lock()
+	wasUp := s.lastErr == nil
+	s.lastErr = err
+	if wasUp {
+		s.downAt = time.Now()
+	}
+	s.mu.Unlock()
+	metricTTSBackendUp.WithLabelValues(name).Set(0)
+	if wasUp {
+		slog.Warn("TTS 实例不可用", "backend", name, "err", err)
+	}
+}
+
+func (s *ttsBackendState) markUp(name string) {
+	s.mu.Lock()
+	wasDown := s.lastErr != nil
+	s.lastErr = nil
+	s.mu.Unlock()
+	metricTTSBackendUp.WithLabelValues(name).Set(1)
+	if wasDown {
+		slog.Info("TTS 实例已恢复", "backend", name)
+	}
+}
+
+// probeTTSBackends 并发探测各实例可达性
+func probeTTSBackends() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, b := range cfg.TTSBackends {
+		wg.Add(1)
+		go func(b TTSBackend) {
+			defer wg.Done()
+			if _, err := checkHTTPReachable(ctx, b.BaseURL); err != nil {
+				ttsState(b.Name).markDown(b.Name, err)
+				return
+			}
+			ttsState(b.Name).markUp(b.Name)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// orderTTSBackends 返回尝试顺序：preferred 优先，其余健康实例按策略排序，不健康实例排在最后兜底
+func orderTTSBackends(preferred string) []TTSBackend {
+	n := len(cfg.TTSBackends)
+	candidates := make([]TTSBackend, 0, n)
+	start := 0
+	if cfg.TTSBalance == ttsBalanceRoundRobin {
+		start = int(ttsRRCursor.Add(1) % uint64(n))
+	}
+	for i := 0; i < n; i++ {
+		candidates = append(candidates, cfg.TTSBackends[(start+i)%n])
+	}
+	rank := func(b TTSBackend) int64 {
+		if !ttsState(b.Name).healthy() || circuitOpen(b.BaseURL) {
+			return 1 << 40
+		}
+		if b.Name == preferred {
+			return -1
+		}
+		if cfg.TTSBalance == ttsBalanceLeastInflight {
+			return ttsState(b.Name).inflight.Load()
+		}
+		return 0
+	}
+	// 稳定排序，保留轮询起点顺序
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && rank(candidates[j]) < rank(candidates[j-1]); j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+	return candidates
+}
+
+// inflightBody 在响应体关闭时释放实例的并发计数
+type inflightBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *inflightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// ttsRequest 向 TTS 池发送请求：连接错误或 5xx 时切换到下一个实例；
+// 返回实际使用的实例名，调用方可记录并在后续请求中作为 preferred 保持粘性
+func ttsRequest(ctx context.Context, path string, body []byte, preferred string) (*http.Response, string, error) {
+	var lastErr error
+	candidates := orderTTSBackends(preferred)
+	for i, b := range candidates {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		st := ttsState(b.Name)
+		st.inflight.Add(1)
+		metricTTSBackendInflight.WithLabelValues(b.Name).Inc()
+		release := func() {
+			st.inflight.Add(-1)
+			metricTTSBackendInflight.WithLabelValues(b.Name).Dec()
+		}
+		resp, err := httpJSON(ctx, http.MethodPost, b.BaseURL+path, body, map[string]string{"Content-Type": "application/json"})
+		last := i == len(candidates)-1
+		switch {
+		case err != nil:
+			release()
+			if ctx.Err() != nil {
+				return nil, "", err
+			}
+			st.markDown(b.Name, err)
+			lastErr = fmt.Errorf("TTS 实例 %s: %w", b.Name, err)
+		case resp.StatusCode >= 500 && !last:
+			snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			release()
+			st.markDown(b.Name, fmt.Errorf("HTTP %d: %s", resp.StatusCode, snippet))
+			lastErr = fmt.Errorf("TTS 实例 %s 返回 HTTP %d", b.Name, resp.StatusCode)
+		default:
+			if resp.StatusCode < 500 {
+				st.markUp(b.Name)
+				metricTTSBackendRequests.WithLabelValues(b.Name, "ok").Inc()
+			} else {
+				metricTTSBackendRequests.WithLabelValues(b.Name, "error").Inc()
+			}
+			resp.Body = &inflightBody{ReadCloser: resp.Body, release: release}
+			return resp, b.Name, nil
+		}
+		if last {
+			metricTTSBackendRequests.WithLabelValues(b.Name, "error").Inc()
+			break
+		}
+		metricTTSBackendRequests.WithLabelValues(b.Name, "failover").Inc()
+		slog.Warn("TTS 请求失败，切换实例", "backend", b.Name, "next", candidates[i+1].Name, "err", lastErr)
+	}
+	return nil, "", lastErr
+}
+
+// GET /api/admin/tts-backends: TTS 实例列表、健康状态与本进程并发数
+func handleAdminTTSBackends(c *gin.Context) {
+	out := make([]gin.H, 0, len(cfg.TTSBackends))
+	for _, b := range cfg.TTSBackends {
+		st := ttsState(b.Name)
+		st.mu.Lock()
+		item := gin.H{
+			"name":     b.Name,
+			"base_url": b.BaseURL,
+			"healthy":  st.lastErr == nil,
+			"inflight": st.inflight.Load(),
+		}
+		if st.lastErr != nil {
+			item["error"] = st.lastErr.Error()
+			item["down_since"] = st.downAt.Unix()
+		}
+		st.mu.Unlock()
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"balance": cfg.TTSBalance, "backends": out})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTTSBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []TTSBackend
+		wantErr bool
+	}{
+		{name: "未配置时使用 TTS_BASE_URL", raw: "",
+			want: []TTSBackend{{Name: "default", BaseURL: "http://tts:8080"}}},
+		{name: "命名实例", raw: "a=http://a:8080/, b = http://b:8080",
+			want: []TTSBackend{{Name: "a", BaseURL: "http://a:8080"}, {Name: "b", BaseURL: "http://b:8080"}}},
+		{name: "省略名称按位置命名", raw: "http://a:8080,,http://c:8080",
+			want: []TTSBackend{{Name: "tts1", BaseURL: "http://a:8080"}, {Name: "tts3", BaseURL: "http://c:8080"}}},
+		{name: "名称重复", raw: "a=http://a:8080,a=http://b:8080", wantErr: true},
+		{name: "只有分隔符", raw: " , ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TTS_BACKENDS", tt.raw)
+			got, err := parseTTSBackends(&Config{TTSBaseURL: "http://tts:8080"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望出错，得到 %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("得到 %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderTTSBackends(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	// 实例状态是进程级的，名称带上测试前缀避免与其他用例共享
+	backends := []TTSBackend{
+		{Name: "order-a", BaseURL: "http://order-a.test"},
+		{Name: "order-b", BaseURL: "http://order-b.test"},
+		{Name: "order-c", BaseURL: "http://order-c.test"},
+	}
+	cfg.TTSBackends = backends
+	ttsState("order-a").markUp("order-a")
+	ttsState("order-b").markDown("order-b", errors.New("connection refused"))
+	ttsState("order-c").markUp("order-c")
+	t.Cleanup(func() { ttsState("order-b").markUp("order-b") })
+	ttsState("order-a").inflight.Store(3)
+	ttsState("order-c").inflight.Store(1)
+	t.Cleanup(func() {
+		ttsState("order-a").inflight.Store(0)
+		ttsState("order-c").inflight.Store(0)
+	})
+
+	names := func(list []TTSBackend) []string {
+		out := make([]string, len(list))
+		for i, b := range list {
+			out[i] = b.Name
+		}
+		return out
+	}
+	tests := []struct {
+		name      string
+		balance   string
+		preferred string
+		want      []string
+	}{
+		{name: "最少并发优先，不健康实例兜底", balance: ttsBalanceLeastInflight,
+			want: []string{"order-c", "order-a", "order-b"}},
+		{name: "preferred 优先", balance: ttsBalanceLeastInflight, preferred: "order-a",
+			want: []string{"order-a", "order-c", "order-b"}},
+		{name: "不健康的 preferred 不优先", balance: ttsBalanceLeastInflight, preferred: "order-b",
+			want: []string{"order-c", "order-a", "order-b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.TTSBalance = tt.balance
+			if got := names(orderTTSBackends(tt.preferred)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderTTSBackends(%q) = %v，期望 %v", tt.preferred, got, tt.want)
+			}
+		})
+	}
+
+	// 轮询：起点依次后移，不健康实例始终在最后
+	cfg.TTSBalance = ttsBalanceRoundRobin
+	firsts := map[string]bool{}
+	for i := 0; i < len(backends); i++ {
+		got := names(orderTTSBackends(""))
+		if got[len(got)-1] != "order-b" {
+			t.Errorf("轮询顺序 %v 中不健康实例应在最后", got)
+		}
+		firsts[got[0]] = true
+	}
+	if !firsts["order-a"] || !firsts["order-c"] {
+		t.Errorf("轮询应轮流从各健康实例开始，实际起点 %v", firsts)
+	}
+}
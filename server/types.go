@@ -37,6 +37,7 @@ type SubmitVideoReq struct {
 	Chaofen         int    `json:"chaofen"`
 	WatermarkSwitch int    `json:"watermark_switch"`
 	PN              int    `json:"pn"`
+	Backend         string `json:"backend"` // 视频后端名称，缺省为默认后端
 }
 
 // 自动化处理请求
@@ -48,6 +49,25 @@ type AutoProcessReq struct {
 	AudioTemplateName string `json:"audio_template_name"`
 	VideoTemplateName string `json:"video_template_name"`
 	TaskName          string `json:"task_name"`
+	// 输入已是模版预处理产物（规范化音频 / 静音视频），流水线跳过对应转换
+	AudioPrepared bool `json:"audio_prepared,omitempty"`
+	VideoPrepared bool `json:"video_prepared,omitempty"`
+	// 任务使用的模版版本，提交时固定，模版后续更新不影响该任务
+	AudioTemplateVersion int `json:"audio_template_version,omitempty"`
+	VideoTemplateVersion int `json:"video_template_version,omitempty"`
+	// 参考音频处理链（规范写法，见 AudioChain），提交时确定
+	AudioFilters string `json:"audio_filters,omitempty"`
+	// 形象视频预处理选项
+	VideoPrep *VideoPrep `json:"video_prep,omitempty"`
+	// 成片字幕选项，nil 表示不生成字幕
+	Subtitles *SubtitleOptions `json:"subtitles,omitempty"`
+	// 成片合成选项（背景音乐、片头片尾、台标），nil 表示不合成
+	Compose *ComposeOptions `json:"compose,omitempty"`
+	// 成片之外需要生成的输出规格，见 renditionProfiles
+	Renditions []string `json:"renditions,omitempty"`
+	// 提交给视频后端的渲染参数与指定的预设名，nil 为引入渲染参数之前的任务
+	Render       *RenderOptions `json:"render,omitempty"`
+	RenderPreset string         `json:"render_preset,omitempty"`
 }
 
 // 自动化处理状态
@@ -68,12 +88,104 @@ type AutoProcessStatus struct {
 	VideoPath     string          `json:"video_path,omitempty"`
 	Request       *AutoProcessReq `json:"request,omitempty"`
 	RetryCount    int             `json:"retry_count,omitempty"`
+	Stages        []StageRecord   `json:"stages,omitempty"`        // 按执行顺序记录的阶段耗时
+	UpdatedAt     int64           `json:"updated_at,omitempty"`    // 最近一次写入状态的时间戳
+	DispatchID    string          `json:"dispatch_id,omitempty"`   // 当前有效的投递 ID
+	Checkpoint    string          `json:"checkpoint,omitempty"`    // 可恢复的执行进度
+	SubmittedAt   int64           `json:"submitted_at,omitempty"`  // 渲染任务提交时间戳
+	RenderCode    string          `json:"render_code,omitempty"`   // 提交给视频后端的任务编号，每次执行唯一
+	WorkerID      string          `json:"worker_id,omitempty"`     // 当前处理该任务的 worker
+	HeartbeatAt   int64           `json:"heartbeat_at,omitempty"`  // 所属 worker 最近一次写入的时间戳
+	Reaped        int             `json:"reaped,omitempty"`        // 因 worker 失联被回收的次数
+	VideoBackend  string          `json:"video_backend,omitempty"` // 承担渲染的视频后端
+	TTSBackend    string          `json:"tts_backend,omitempty"`   // 最近一次合成使用的 TTS 实例
+	AudioChain    string          `json:"audio_chain,omitempty"`   // 实际应用的 ffmpeg 音频滤镜
+	// 字幕文件名（位于结果目录），是否已烧录进成片，以及字幕失败原因（不影响成片）
+	SubtitleFiles   []string `json:"subtitle_files,omitempty"`
+	SubtitlesBurned bool     `json:"subtitles_burned,omitempty"`
+	SubtitleError   string   `json:"subtitle_error,omitempty"`
+	// TTS 语音识别返回的逐句时间戳，字幕据此对齐；自带音频或 TTS 未返回时为空
+	SpeechTimeline []speechSegment   `json:"speech_timeline,omitempty"`
+	Composed       bool              `json:"composed,omitempty"`   // 已完成背景音乐、片头片尾与台标合成
+	Renditions     []RenditionResult `json:"renditions,omitempty"` // 成片的派生输出
+	// 提交渲染时驱动音频的时长（秒），取回成片后的校验结果，以及合成片头片尾等之后对最终成片的再次校验
+	DrivingAudioSeconds  float64             `json:"driving_audio_seconds,omitempty"`
+	Verification         *OutputVerification `json:"verification,omitempty"`
+	ComposedVerification *OutputVerification `json:"composed_verification,omitempty"`
+}
+
+// speechSegment 合成音频中的一句话及其起止时间（秒，相对音频起点）
+type speechSegment struct {
+	Text  string  `json:"text"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// RenditionResult 一个输出规格的生成结果，文件位于结果目录
+type RenditionResult struct {
+	Profile   string  `json:"profile"`
+	Filename  string  `json:"filename,omitempty"`
+	SizeBytes int64   `json:"size_bytes,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// 流水线单个阶段的执行记录
+type StageRecord struct {
+	Name       string `json:"name"`
+	Attempt    int    `json:"attempt"`
+	StartMs    int64  `json:"start_ms"`
+	EndMs      int64  `json:"end_ms,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Outcome    string `json:"outcome"`          // "running", "ok", "failed"
+	Detail     string `json:"detail,omitempty"` // 上游响应片段
+	Error      string `json:"error,omitempty"`
+	CacheHit   bool   `json:"cache_hit,omitempty"` // 产出来自媒体缓存
 }
 
 type TemplateItem struct {
-	Name         string `json:"name"`
-	DisplayName  string `json:"display_name"`
-	OriginalName string `json:"original_name"`
-	Kind         string `json:"kind"`
-	UpdatedAt    int64  `json:"updated_at"`
+	Name         string        `json:"name"`
+	DisplayName  string        `json:"display_name"`
+	OriginalName string        `json:"original_name"`
+	Kind         string        `json:"kind"`
+	UpdatedAt    int64         `json:"updated_at"`
+	Prepared     bool          `json:"prepared,omitempty"` // 已生成流水线就绪产物
+	Meta         *TemplateMeta `json:"meta,omitempty"`     // 上传时 ffprobe 探测的媒体信息
+	Owner        string        `json:"owner,omitempty"`    // 上传者；旧模版为空，视为公共
+	Shared       bool          `json:"shared"`             // 是否对其他用户可见
+	Tags         []string      `json:"tags,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	Folder       string        `json:"folder,omitempty"`        // 分组路径，如 "客服/女声"
+	Version      int           `json:"version"`                 // 当前版本；0 为引入版本管理之前上传的模版
+	AudioFilters string        `json:"audio_filters,omitempty"` // 音频模版产物使用的处理链
+	Render       *RenderPreset `json:"render,omitempty"`        // 视频模版的渲染参数
+}
+
+// 模版的一个不可变版本
+type TemplateVersion struct {
+	Version      int           `json:"version"`
+	OriginalName string        `json:"original_name"`
+	CreatedAt    int64         `json:"created_at"`
+	CreatedBy    string        `json:"created_by,omitempty"`
+	Prepared     bool          `json:"prepared,omitempty"`
+	Meta         *TemplateMeta `json:"meta,omitempty"`
+	AudioFilters string        `json:"audio_filters,omitempty"`
+	Render       *RenderPreset `json:"render,omitempty"`
+}
+
+// 模版媒体信息
+type TemplateMeta struct {
+	Duration   float64 `json:"duration"` // 秒
+	SizeBytes  int64   `json:"size_bytes"`
+	Format     string  `json:"format"`
+	Codec      string  `json:"codec"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	BitRate    int64   `json:"bit_rate,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FPS        float64 `json:"fps,omitempty"`
 }
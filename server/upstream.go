@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上游接口类型，决定超时设置
+const (
+	endpointTTSPreprocess = "tts_preprocess"
+	endpointTTSInvoke     = "tts_invoke"
+	endpointVideoSubmit   = "video_submit"
+	endpointDefault       = "default"
+)
+
+// UpstreamTimeout 单类接口的超时：Connect 为建立连接，Header 为等待响应头，Total 含读取响应体
+type UpstreamTimeout struct {
+	Connect time.Duration
+	Header  time.Duration
+	Total   time.Duration
+}
+
+var defaultUpstreamTimeouts = map[string]UpstreamTimeout{
+	endpointTTSPreprocess: {Header: 60 * time.Second, Total: 2 * time.Minute},
+	endpointTTSInvoke:     {Header: 5 * time.Minute, Total: 10 * time.Minute},
+	endpointVideoSubmit:   {Header: 30 * time.Second, Total: time.Minute},
+	endpointDefault:       {Header: 10 * time.Minute, Total: 10 * time.Minute},
+}
+
+// parseUpstreamTimeouts 读取 UPSTREAM_TIMEOUTS（如 "tts_invoke=300/600,video_submit=3/30/60"，单位秒，
+// [connect/]header/total）；未指定连接超时的类型使用 connect
+func parseUpstreamTimeouts(raw string, connect time.Duration) (map[string]UpstreamTimeout, error) {
+	out := make(map[string]UpstreamTimeout, len(defaultUpstreamTimeouts))
+	for k, v := range defaultUpstreamTimeouts {
+		v.Connect = connect
+		out[k] = v
+	}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, spec, ok := strings.Cut(item, "=")
+		if _, known := out[kind]; !ok || !known {
+			return nil, fmt.Errorf("UPSTREAM_TIMEOUTS 非法项: %q", item)
+		}
+		parts := strings.Split(spec, "/")
+		secs := make([]int, len(parts))
+		valid := len(parts) == 2 || len(parts) == 3
+		for i, p := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || n <= 0 {
+				valid = false
+			}
+			secs[i] = n
+		}
+		if !valid || secs[len(secs)-1] < secs[len(secs)-2] {
+			return nil, fmt.Errorf("UPSTREAM_TIMEOUTS 非法项: %q（格式 类型=[连接秒数/]响应头秒数/总秒数）", item)
+		}
+		t := UpstreamTimeout{Connect: connect}
+		if len(secs) == 3 {
+			t.Connect = time.Duration(secs[0]) * time.Second
+			secs = secs[1:]
+		}
+		t.Header, t.Total = time.Duration(secs[0])*time.Second, time.Duration(secs[1])*time.Second
+		out[kind] = t
+	}
+	return out, nil
+}
+
+func endpointKind(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/v1/preprocess_and_tran"):
+		return endpointTTSPreprocess
+	case strings.HasSuffix(path, "/v1/invoke"):
+		return endpointTTSInvoke
+	case strings.HasSuffix(path, "/easy/submit"):
+		return endpointVideoSubmit
+	}
+	return endpointDefault
+}
+
+// 每类上游的每类接口共用一个 client，复用连接；连接超时随接口类型设置在 transport 上，
+// 响应头与总超时由 upstreamDo 控制
+var (
+	upstreamClientsMu sync.Mutex
+	upstreamClients   = map[string]*http.Client{}
+)
+
+// upstreamClient 按上游与接口类型复用连接池；连接与响应头超时由 transport 负责，总超时由调用方的 context 控制
+func upstreamClient(upstream, kind string, t UpstreamTimeout) *http.Client {
+	key := upstream + "/" + kind
+	upstreamClientsMu.Lock()
+	defer upstreamClientsMu.Unlock()
+	if c, ok := upstreamClients[key]; ok {
+		return c
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   t.Connect,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: t.Header,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	c := &http.Client{Transport: transport}
+	upstreamClients[key] = c
+	return c
+}
+
+// 熔断器状态
+const (
+	breakerClosed = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+var errCircuitOpen = errors.New("上游熔断中")
+
+// circuitBreaker 按上游实例（scheme+host）统计连续失败：达到阈值后熔断 cfg.BreakerCooldown，
+// 冷却结束放行一个试探请求，成功则恢复，失败继续熔断
+type circuitBreaker struct {
+	upstream string
+	target   string
+
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var breakers sync.Map // target -> *circuitBreaker
+
+func breakerTarget(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return rawURL
+}
+
+func breakerFor(upstream, rawURL string) *circuitBreaker {
+	target := breakerTarget(rawURL)
+	v, _ := breakers.LoadOrStore(target, &circuitBreaker{upstream: upstream, target: target})
+	return v.(*circuitBreaker)
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return fmt.Errorf("%w: %s（%s 后重试）", errCircuitOpen, b.target, time.Until(b.openUntil).Round(time.Second))
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w: %s（试探请求进行中）", errCircuitOpen, b.target)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			slog.Info("上游熔断恢复", "upstream", b.upstream, "target", b.target)
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= cfg.BreakerFailures {
+		if b.state != breakerOpen {
+			slog.Warn("上游熔断", "upstream", b.upstream, "target", b.target, "failures", b.failures, "cooldown", cfg.BreakerCooldown.String())
+		}
+		b.openUntil = time.Now().Add(cfg.BreakerCooldown)
+		b.setState(breakerOpen)
+	}
+}
+
+// abort 请求被调用方取消，不计成败，仅释放试探名额
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	metricCircuitState.WithLabelValues(b.upstream, b.target).Set(float64(state))
+}
+
+// isOpen 判断是否处于熔断期（半开视为可用）
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen && time.Now().Before(b.openUntil)
+}
+
+// openUntilTime 返回熔断结束时间，未熔断时为零值
+func (b *circuitBreaker) openUntilTime() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return time.Time{}
+	}
+	return b.openUntil
+}
+
+func circuitOpen(rawURL string) bool {
+	v, ok := breakers.Load(breakerTarget(rawURL))
+	return ok && v.(*circuitBreaker).isOpen()
+}
+
+// allCircuitsOpen 判断一组上游是否全部熔断，返回最早恢复试探的时间
+func allCircuitsOpen(baseURLs []string) (bool, time.Time) {
+	var earliest time.Time
+	for _, u := range baseURLs {
+		v, ok := breakers.Load(breakerTarget(u))
+		if !ok {
+			return false, time.Time{}
+		}
+		until := v.(*circuitBreaker).openUntilTime()
+		if until.IsZero() || !time.Now().Before(until) {
+			return false, time.Time{}
+		}
+		if earliest.IsZero() || until.Before(earliest) {
+			earliest = until
+		}
+	}
+	return len(baseURLs) > 0, earliest
+}
+
+// upstreamHold 判断任务接下来依赖的上游是否全部熔断；是则返回原因与可重试时间
+func upstreamHold(status *AutoProcessStatus, req AutoProcessReq) (string, time.Time) {
+	if status.Checkpoint == checkpointSubmitted {
+		return "", time.Time{}
+	}
+	videoURLs := make([]string, 0, len(cfg.VideoBackends))
+	for _, b := range cfg.VideoBackends {
+		videoURLs = append(videoURLs, b.BaseURL)
+	}
+	if open, until := allCircuitsOpen(videoURLs); open {
+		return "视频合成服务熔断中", until
+	}
+	if req.UseTTS {
+		ttsURLs := make([]string, 0, len(cfg.TTSBackends))
+		for _, b := range cfg.TTSBackends {
+			ttsURLs = append(ttsURLs, b.BaseURL)
+		}
+		if open, until := allCircuitsOpen(ttsURLs); open {
+			return "TTS 服务熔断中", until
+		}
+	}
+	return "", time.Time{}
+}
+
+// cancelOnClose 响应体关闭时释放请求的超时 context
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// upstreamDo 经熔断器发送请求：连接、响应头与总超时均按接口类型区分
+func upstreamDo(ctx context.Context, req *http.Request, upstream, endpoint string) (*http.Response, error) {
+	br := breakerFor(upstream, req.URL.String())
+	if err := br.allow(); err != nil {
+		return nil, err
+	}
+	kind := endpointKind(endpoint)
+	t, ok := cfg.UpstreamTimeouts[kind]
+	if !ok {
+		t = defaultUpstreamTimeouts[endpointDefault]
+	}
+	if t.Connect <= 0 {
+		t.Connect = cfg.UpstreamConnectTimeout
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, t.Total)
+	resp, err := upstreamClient(upstream, kind, t).Do(req.WithContext(reqCtx))
+	if err != nil {
+		// context 未到期的超时来自 transport：连接超时或等待响应头超时
+		var ne net.Error
+		if reqCtx.Err() == nil && errors.As(err, &ne) && ne.Timeout() {
+			err = fmt.Errorf("%s 连接或等待响应头超时（连接 %s，响应头 %s）: %w", kind, t.Connect, t.Header, err)
+		}
+		cancel()
+		// 调用方取消（停机等）不计入熔断
+		if ctx.Err() != nil {
+			br.abort()
+		} else {
+			br.record(true)
+		}
+		return nil, err
+	}
+	br.record(resp.StatusCode >= 500)
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreamTimeouts(t *testing.T) {
+	connect := 5 * time.Second
+	tests := []struct {
+		name    string
+		raw     string
+		kind    string
+		want    UpstreamTimeout
+		wantErr bool
+	}{
+		{name: "空串使用默认值", raw: "", kind: endpointTTSInvoke,
+			want: UpstreamTimeout{Connect: connect, Header: 5 * time.Minute, Total: 10 * time.Minute}},
+		{name: "响应头与总超时", raw: "tts_invoke=300/600", kind: endpointTTSInvoke,
+			want: UpstreamTimeout{Connect: connect, Header: 300 * time.Second, Total: 600 * time.Second}},
+		{name: "含连接超时", raw: "video_submit=3/30/60", kind: endpointVideoSubmit,
+			want: UpstreamTimeout{Connect: 3 * time.Second, Header: 30 * time.Second, Total: 60 * time.Second}},
+		{name: "多项与空白", raw: " tts_invoke=1/2 , video_submit=4/5 ", kind: endpointVideoSubmit,
+			want: UpstreamTimeout{Connect: connect, Header: 4 * time.Second, Total: 5 * time.Second}},
+		{name: "未覆盖的类型保持默认", raw: "tts_invoke=1/2", kind: endpointDefault,
+			want: UpstreamTimeout{Connect: connect, Header: 10 * time.Minute, Total: 10 * time.Minute}},
+		{name: "未知类型", raw: "unknown=1/2", wantErr: true},
+		{name: "缺少等号", raw: "tts_invoke", wantErr: true},
+		{name: "只有一个值", raw: "tts_invoke=30", wantErr: true},
+		{name: "值过多", raw: "tts_invoke=1/2/3/4", wantErr: true},
+		{name: "非数字", raw: "tts_invoke=a/2", wantErr: true},
+		{name: "非正数", raw: "tts_invoke=0/2", wantErr: true},
+		{name: "总超时小于响应头超时", raw: "tts_invoke=60/30", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUpstreamTimeouts(tt.raw, connect)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望出错，得到 %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if got[tt.kind] != tt.want {
+				t.Errorf("%s = %+v，期望 %+v", tt.kind, got[tt.kind], tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.BreakerFailures = 2
+	cfg.BreakerCooldown = time.Minute
+
+	// 每一步：可选地把冷却期拨到已结束，然后 allow；allow 放行时按 result 记录结果
+	type step struct {
+		expire  bool   // 先让冷却期结束
+		allowed bool   // 期望 allow 放行
+		result  string // "ok"、"fail"、"abort"；未放行时忽略
+		state   int    // 本步之后的状态
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "连续失败达到阈值后熔断", steps: []step{
+			{allowed: true, result: "fail", state: breakerClosed},
+			{allowed: true, result: "fail", state: breakerOpen},
+			{allowed: false, state: breakerOpen},
+		}},
+		{name: "成功清零失败计数", steps: []step{
+			{allowed: true, result: "fail", state: breakerClosed},
+			{allowed: true, result: "ok", state: breakerClosed},
+			{allowed: true, result: "fail", state: breakerClosed},
+		}},
+		{name: "冷却结束后试探成功则恢复", steps: []step{
+			{allowed: true, result: "fail", state: breakerClosed},
+			{allowed: true, result: "fail", state: breakerOpen},
+			{expire: true, allowed: true, result: "ok", state: breakerClosed},
+			{allowed: true, result: "ok", state: breakerClosed},
+		}},
+		{name: "试探失败继续熔断", steps: []step{
+			{allowed: true, result: "fail", state: breakerClosed},
+			{allowed: true, result: "fail", state: breakerOpen},
+			{expire: true, allowed: true, result: "fail", state: breakerOpen},
+			{allowed: false, state: breakerOpen},
+		}},
+		{name: "试探被取消后可再次试探", steps: []step{
+			{allowed: true, result: "fail", state: breakerClosed},
+			{allowed: true, result: "fail", state: breakerOpen},
+			{expire: true, allowed: true, result: "abort", state: breakerHalfOpen},
+			{allowed: true, result: "ok", state: breakerClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &circuitBreaker{upstream: "test", target: "http://breaker.test"}
+			for i, s := range tt.steps {
+				if s.expire {
+					b.openUntil = time.Now().Add(-time.Second)
+				}
+				err := b.allow()
+				if allowed := err == nil; allowed != s.allowed {
+					t.Fatalf("第 %d 步 allow 放行=%v，期望 %v（err=%v）", i, allowed, s.allowed, err)
+				}
+				if err != nil && !errors.Is(err, errCircuitOpen) {
+					t.Fatalf("第 %d 步错误应为 errCircuitOpen: %v", i, err)
+				}
+				if err == nil {
+					switch s.result {
+					case "ok":
+						b.record(false)
+					case "fail":
+						b.record(true)
+					case "abort":
+						b.abort()
+					}
+				}
+				if b.state != s.state {
+					t.Fatalf("第 %d 步后状态 %d，期望 %d", i, b.state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.BreakerFailures = 1
+	cfg.BreakerCooldown = time.Minute
+
+	b := &circuitBreaker{upstream: "test", target: "http://probe.test"}
+	b.record(true)
+	if !b.isOpen() || b.openUntilTime().IsZero() {
+		t.Fatal("失败一次后应熔断")
+	}
+	b.openUntil = time.Now().Add(-time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("冷却结束后应放行试探请求: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("试探进行中应拒绝其他请求，得到 %v", err)
+	}
+	if b.isOpen() {
+		t.Error("半开状态不应视为熔断")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -22,7 +22,9 @@ func run(ctx context.Context, name string, args ...string) (string, string, erro
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	started := time.Now()
 	err := cmd.Run()
+	observeCommand(name, started, err)
 	return stdout.String(), stderr.String(), err
 }
 
@@ -45,12 +47,11 @@ func saveMultipartFile(file *multipart.FileHeader, dstDir, wantName string) (str
 		return "", errors.New("file 不能为空")
 	}
 
-	// 添加调试信息
-	log.Printf("保存文件: %s, 大小: %d, 目标目录: %s", file.Filename, file.Size, dstDir)
+	slog.Debug("保存上传文件", "filename", file.Filename, "size", file.Size, "dir", dstDir)
 
 	src, err := file.Open()
 	if err != nil {
-		log.Printf("打开文件失败: %v", err)
+		slog.Error("打开上传文件失败", "filename", file.Filename, "err", err)
 		return "", err
 	}
 	defer src.Close()
@@ -76,6 +77,28 @@ func saveMultipartFile(file *multipart.FileHeader, dstDir, wantName string) (str
 	return dst, nil
 }
 
+// stageMultipartFile 把上传文件写入 dstDir 下按 pattern（同 os.CreateTemp）生成的唯一文件；
+// 写入失败时仍返回已创建的路径，由调用方删除
+func stageMultipartFile(file *multipart.FileHeader, dstDir, pattern string) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return "", err
+	}
+	out, err := os.CreateTemp(dstDir, pattern)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, src); err != nil {
+		return out.Name(), err
+	}
+	return out.Name(), nil
+}
+
 func sanitizeFilename(name string) string {
 	name = filepath.Base(name)
 	name = strings.ReplaceAll(name, "..", "_")
@@ -115,7 +138,9 @@ func copyFile(src, dst string) error {
 		return err
 	}
 	defer out.Close()
-	if _, err := io.Copy(out, in); err != nil {
+	n, err := io.Copy(out, in)
+	addBytesTransferred("copy", n)
+	if err != nil {
 		return err
 	}
 	return out.Close()
@@ -132,8 +157,22 @@ func httpJSON(ctx context.Context, method, url string, body []byte, headers map[
 	if req.Header.Get("Content-Type") == "" {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	client := &http.Client{Timeout: 10 * time.Minute}
-	return client.Do(req)
+	// 透传请求 ID，便于在 TTS/视频服务日志中关联
+	if rid := requestIDFrom(ctx); rid != "" && req.Header.Get(requestIDHeader) == "" {
+		req.Header.Set(requestIDHeader, rid)
+	}
+	upstream, endpoint := upstreamLabels(url)
+	started := time.Now()
+	resp, err := upstreamDo(ctx, req, upstream, endpoint)
+	if errors.Is(err, errCircuitOpen) {
+		return nil, err
+	}
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	observeUpstream(url, started, statusCode, err)
+	return resp, err
 }
 
 func sanitizeTaskName(name string) string {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// InputLimits 提交任务时对素材与文本的校验上限
+type InputLimits struct {
+	MinAudioSeconds float64
+	MaxAudioSeconds float64
+	MinVideoSeconds float64
+	MaxVideoSeconds float64
+	MaxLongEdge     int     // 视频长边像素上限
+	MinShortEdge    int     // 视频短边像素下限，过小时人脸难以检测
+	MinAspect       float64 // 宽高比下限（竖屏 9:16 约 0.56）
+	MaxAspect       float64 // 宽高比上限（横屏 16:9 约 1.78）
+	MaxTextChars    int
+	// 正倒放补齐时倒放片段的时长上限（秒），倒放需要把整段画面缓存在内存中
+	MaxPingPongSeconds float64
+}
+
+// inputIssue 一条校验失败，随 400 响应返回给前端逐项展示
+type inputIssue struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validateAutoInputs 在入队前同步校验文本与素材，返回全部问题（为空表示通过）
+func validateAutoInputs(ctx context.Context, req AutoProcessReq, audioPath, videoPath string) []inputIssue {
+	lim := cfg.InputLimits
+	var issues []inputIssue
+	add := func(field, code, format string, args ...any) {
+		issues = append(issues, inputIssue{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if req.UseTTS {
+		text := strings.TrimSpace(req.Text)
+		switch {
+		case text == "":
+			add("text", "text_empty", "使用 TTS 时合成文本不能为空")
+		case lim.MaxTextChars > 0 && utf8.RuneCountInString(text) > lim.MaxTextChars:
+			add("text", "text_too_long", "合成文本最多 %d 字，当前 %d 字", lim.MaxTextChars, utf8.RuneCountInString(text))
+		}
+	}
+
+	if sub := req.Subtitles; sub != nil {
+		text := strings.TrimSpace(subtitleText(req))
+		switch {
+		case text == "":
+			add("subtitle_text", "text_empty", "自带音频的任务生成字幕需提供 subtitle_text")
+		case lim.MaxTextChars > 0 && utf8.RuneCountInString(text) > lim.MaxTextChars:
+			add("subtitle_text", "text_too_long", "字幕文本最多 %d 字，当前 %d 字", lim.MaxTextChars, utf8.RuneCountInString(text))
+		}
+	}
+
+	if probe, err := probeMedia(ctx, audioPath); err != nil {
+		add("audio", "unreadable", "音频无法解析，请确认上传的是音频文件")
+	} else if probe.stream("audio") == nil {
+		add("audio", "no_audio_stream", "文件中没有音轨")
+	} else {
+		checkDuration(add, "audio", "音频", probe.duration(), lim.MinAudioSeconds, lim.MaxAudioSeconds)
+	}
+
+	probe, err := probeMedia(ctx, videoPath)
+	if err != nil {
+		add("video", "unreadable", "视频无法解析，请确认上传的是视频文件")
+		return issues
+	}
+	v := probe.stream("video")
+	// 单帧图片也会被识别为视频流，用时长区分
+	if v == nil || probe.duration() == 0 {
+		add("video", "no_video_stream", "文件中没有视频画面")
+		return issues
+	}
+	checkDuration(add, "video", "视频", probe.duration(), lim.MinVideoSeconds, lim.MaxVideoSeconds)
+	if p := req.VideoPrep; p != nil && p.TrimStart > 0 && p.TrimStart >= probe.duration() {
+		add("video_trim_start", "out_of_range", "截取起点 %.1f 秒超出视频时长 %.1f 秒", p.TrimStart, probe.duration())
+	}
+	if p := req.VideoPrep; p != nil && p.Fill == videoFillPingPong && lim.MaxPingPongSeconds > 0 {
+		clip := probe.duration()
+		if p.TrimEnd > 0 && p.TrimEnd < clip {
+			clip = p.TrimEnd
+		}
+		clip -= p.TrimStart
+		if clip > lim.MaxPingPongSeconds {
+			add("video_fill", "pingpong_too_long", "正倒放补齐的视频片段 %.1f 秒，不能超过 %.0f 秒，请截取片段或改用 loop", clip, lim.MaxPingPongSeconds)
+		}
+	}
+	w, h := v.displaySize()
+	if w <= 0 || h <= 0 {
+		add("video", "unknown_resolution", "无法识别视频分辨率")
+		return issues
+	}
+	if p := req.VideoPrep; p != nil && p.Crop != "" {
+		var cw, ch, cx, cy int
+		fmt.Sscanf(p.Crop, "%d:%d:%d:%d", &cw, &ch, &cx, &cy)
+		if cw == 0 || ch == 0 || cx+cw > w || cy+ch > h {
+			add("video_crop", "out_of_range", "裁剪区域 %s 超出画面 %dx%d", p.Crop, w, h)
+		}
+	}
+	long, short := max(w, h), min(w, h)
+	if lim.MaxLongEdge > 0 && long > lim.MaxLongEdge {
+		add("video", "resolution_too_high", "视频分辨率 %dx%d 过高，长边不能超过 %d", w, h, lim.MaxLongEdge)
+	}
+	if short < lim.MinShortEdge {
+		add("video", "resolution_too_low", "视频分辨率 %dx%d 过低，短边至少 %d", w, h, lim.MinShortEdge)
+	}
+	aspect := float64(w) / float64(h)
+	if (lim.MinAspect > 0 && aspect < lim.MinAspect) || (lim.MaxAspect > 0 && aspect > lim.MaxAspect) {
+		add("video", "aspect_ratio", "视频宽高比 %.2f 超出范围 %.2f-%.2f，过窄或过宽的画面不利于人脸合成", aspect, lim.MinAspect, lim.MaxAspect)
+	}
+	return issues
+}
+
+func checkDuration(add func(field, code, format string, args ...any), field, label string, seconds, minSeconds, maxSeconds float64) {
+	if seconds < minSeconds {
+		add(field, "too_short", "%s时长 %.1f 秒，至少需要 %.1f 秒", label, seconds, minSeconds)
+	}
+	if maxSeconds > 0 && seconds > maxSeconds {
+		add(field, "too_long", "%s时长 %.1f 秒，不能超过 %.0f 秒", label, seconds, maxSeconds)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeFFprobe 在 PATH 前放一个假的 ffprobe：把被探测文件的内容当作 JSON 输出，内容为 "bad" 时失败
+func fakeFFprobe(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor a; do last=$a; done\nif [ \"$(cat \"$last\")\" = bad ]; then echo 'Invalid data' >&2; exit 1; fi\ncat \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func writeProbe(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateAutoInputs(t *testing.T) {
+	dir := fakeFFprobe(t)
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.InputLimits = InputLimits{
+		MinAudioSeconds:    1,
+		MaxAudioSeconds:    600,
+		MinVideoSeconds:    1,
+		MaxVideoSeconds:    600,
+		MaxLongEdge:        3840,
+		MinShortEdge:       256,
+		MinAspect:          0.5,
+		MaxAspect:          2.0,
+		MaxTextChars:       10,
+		MaxPingPongSeconds: 30,
+	}
+
+	audio := writeProbe(t, dir, "ok.wav", `{"format":{"duration":"12.5"},"streams":[{"codec_type":"audio"}]}`)
+	video := writeProbe(t, dir, "ok.mp4", `{"format":{"duration":"60"},"streams":[{"codec_type":"video","width":1080,"height":1920},{"codec_type":"audio"}]}`)
+	probes := map[string]string{
+		"bad":        "bad",
+		"coverOnly":  `{"format":{"duration":"5"},"streams":[{"codec_type":"video","disposition":{"attached_pic":1}}]}`,
+		"shortAudio": `{"format":{"duration":"0.4"},"streams":[{"codec_type":"audio"}]}`,
+		"image":      `{"format":{"duration":""},"streams":[{"codec_type":"video","width":800,"height":600}]}`,
+		"longVideo":  `{"format":{"duration":"900"},"streams":[{"codec_type":"video","width":1080,"height":1920}]}`,
+		"tiny":       `{"format":{"duration":"10"},"streams":[{"codec_type":"video","width":200,"height":320}]}`,
+		"huge":       `{"format":{"duration":"10"},"streams":[{"codec_type":"video","width":7680,"height":4320}]}`,
+		"wide":       `{"format":{"duration":"10"},"streams":[{"codec_type":"video","width":1920,"height":600}]}`,
+		"rotated":    `{"format":{"duration":"10"},"streams":[{"codec_type":"video","width":1920,"height":1080,"side_data_list":[{"rotation":-90}]}]}`,
+		"noSize":     `{"format":{"duration":"10"},"streams":[{"codec_type":"video"}]}`,
+		"shortVideo": `{"format":{"duration":"0.5"},"streams":[{"codec_type":"video","width":1080,"height":1920}]}`,
+	}
+	paths := map[string]string{}
+	for name, content := range probes {
+		paths[name] = writeProbe(t, dir, name, content)
+	}
+
+	tests := []struct {
+		name  string
+		req   AutoProcessReq
+		audio string
+		video string
+		want  []string // field/code
+	}{
+		{name: "全部通过", req: AutoProcessReq{UseTTS: true, Text: "你好"}, audio: audio, video: video},
+		{name: "TTS 文本为空", req: AutoProcessReq{UseTTS: true, Text: "  "}, audio: audio, video: video,
+			want: []string{"text/text_empty"}},
+		{name: "TTS 文本过长", req: AutoProcessReq{UseTTS: true, Text: strings.Repeat("字", 11)}, audio: audio, video: video,
+			want: []string{"text/text_too_long"}},
+		{name: "自带音频生成字幕需提供文本", req: AutoProcessReq{Subtitles: &SubtitleOptions{}}, audio: audio, video: video,
+			want: []string{"subtitle_text/text_empty"}},
+		{name: "素材无法解析", audio: paths["bad"], video: paths["bad"],
+			want: []string{"audio/unreadable", "video/unreadable"}},
+		{name: "封面图不算流", audio: paths["coverOnly"], video: paths["coverOnly"],
+			want: []string{"audio/no_audio_stream", "video/no_video_stream"}},
+		{name: "音频过短", audio: paths["shortAudio"], video: video, want: []string{"audio/too_short"}},
+		{name: "单帧图片", audio: audio, video: paths["image"], want: []string{"video/no_video_stream"}},
+		{name: "视频过长", audio: audio, video: paths["longVideo"], want: []string{"video/too_long"}},
+		{name: "视频过短", audio: audio, video: paths["shortVideo"], want: []string{"video/too_short"}},
+		{name: "分辨率过低", audio: audio, video: paths["tiny"], want: []string{"video/resolution_too_low"}},
+		{name: "分辨率过高", audio: audio, video: paths["huge"], want: []string{"video/resolution_too_high"}},
+		{name: "宽高比超出范围", audio: audio, video: paths["wide"], want: []string{"video/aspect_ratio"}},
+		{name: "旋转后按显示尺寸裁剪", req: AutoProcessReq{VideoPrep: &VideoPrep{Crop: "1080:1920:0:0"}}, audio: audio, video: paths["rotated"]},
+		{name: "裁剪超出画面", req: AutoProcessReq{VideoPrep: &VideoPrep{Crop: "1920:1080:0:0"}}, audio: audio, video: paths["rotated"],
+			want: []string{"video_crop/out_of_range"}},
+		{name: "分辨率未知", audio: audio, video: paths["noSize"], want: []string{"video/unknown_resolution"}},
+		{name: "截取起点超出时长", req: AutoProcessReq{VideoPrep: &VideoPrep{TrimStart: 60}}, audio: audio, video: video,
+			want: []string{"video_trim_start/out_of_range"}},
+		{name: "正倒放片段过长", req: AutoProcessReq{VideoPrep: &VideoPrep{Fill: videoFillPingPong}}, audio: audio, video: video,
+			want: []string{"video_fill/pingpong_too_long"}},
+		{name: "正倒放截取后不超限", req: AutoProcessReq{VideoPrep: &VideoPrep{Fill: videoFillPingPong, TrimStart: 10, TrimEnd: 35}}, audio: audio, video: video},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range validateAutoInputs(context.Background(), tt.req, tt.audio, tt.video) {
+				got = append(got, issue.Field+"/"+issue.Code)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("得到 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputVerification 成片校验结果，随任务记录
+type OutputVerification struct {
+	Passed         bool     `json:"passed"`
+	SizeBytes      int64    `json:"size_bytes"`
+	Format         string   `json:"format,omitempty"`
+	VideoCodec     string   `json:"video_codec,omitempty"`
+	AudioCodec     string   `json:"audio_codec,omitempty"`
+	Width          int      `json:"width,omitempty"`
+	Height         int      `json:"height,omitempty"`
+	FPS            float64  `json:"fps,omitempty"`
+	Duration       float64  `json:"duration"`                // 容器时长
+	VideoDuration  float64  `json:"video_duration"`          // 视频流时长
+	AudioDuration  float64  `json:"audio_duration"`          // 音频流时长
+	DrivingAudio   float64  `json:"driving_audio,omitempty"` // 预期时长：驱动音频时长，合成后另加片头片尾
+	DurationDiff   float64  `json:"duration_diff,omitempty"` // 视频流时长 - 预期时长
+	AVOffset       float64  `json:"av_offset"`               // 音频流起点 - 视频流起点
+	AVDurationDiff float64  `json:"av_duration_diff"`        // 视频流时长 - 音频流时长
+	MoovFirst      bool     `json:"moov_first"`              // moov 位于 mdat 之前（可边下边播）
+	DecodeErrors   int      `json:"decode_errors"`           // 完整解码时的错误条数，-1 表示未解码
+	Failures       []string `json:"failures,omitempty"`      // 未通过的检查项
+	CheckedAt      int64    `json:"checked_at"`
+}
+
+// 音视频流起点偏差的容忍值（秒），超出即视为音画不同步
+const maxAVOffset = 0.2
+
+// mp4Boxes 读取 MP4 顶层 box 的类型；任一 box 越过文件末尾说明文件被截断
+func mp4Boxes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var boxes []string
+	var offset int64
+	header := make([]byte, 16)
+	for offset < st.Size() {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return boxes, fmt.Errorf("偏移 %d 处的 box 头不完整", offset)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		switch size {
+		case 0:
+			// 延伸到文件末尾
+			size = st.Size() - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil && err != io.EOF {
+				return boxes, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return boxes, fmt.Errorf("偏移 %d 处的 box %q 大小非法: %d", offset, kind, size)
+		}
+		if offset+size > st.Size() {
+			return boxes, fmt.Errorf("box %q 声明 %d 字节，文件在 %d 字节处截断", kind, size, st.Size())
+		}
+		boxes = append(boxes, kind)
+		offset += size
+	}
+	return boxes, nil
+}
+
+// countDecodeErrors 完整解码一遍成片，返回 ffmpeg 报告的错误条数
+func countDecodeErrors(ctx context.Context, path string) (int, error) {
+	_, stderr, err := run(ctx, "ffmpeg", "-v", "error", "-nostats", "-i", path, "-f", "null", "-")
+	stderr = strings.TrimSpace(stderr)
+	n := 0
+	if stderr != "" {
+		n = len(strings.Split(stderr, "\n"))
+	}
+	if err != nil && n == 0 {
+		return 0, err
+	}
+	return n, nil
+}
+
+func streamDuration(s *probeStream, fallback float64) float64 {
+	if s == nil {
+		return 0
+	}
+	if d, err := strconv.ParseFloat(s.Duration, 64); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// verifyOutput 检查成片的结构、可解码性、时长与音画同步；drivingAudio 为预期时长，
+// expected 描述其来源用于失败原因，drivingAudio 为 0 时跳过时长比较
+func verifyOutput(ctx context.Context, path string, drivingAudio float64, expected string) *OutputVerification {
+	v := &OutputVerification{DrivingAudio: drivingAudio, DecodeErrors: -1, CheckedAt: time.Now().Unix()}
+	fail := func(format string, args ...any) {
+		v.Failures = append(v.Failures, fmt.Sprintf(format, args...))
+	}
+	defer func() { v.Passed = len(v.Failures) == 0 }()
+
+	if st, err := os.Stat(path); err != nil {
+		fail("成片不存在: %v", err)
+		return v
+	} else {
+		v.SizeBytes = st.Size()
+	}
+	boxes, err := mp4Boxes(path)
+	if err != nil {
+		fail("MP4 结构异常: %v", err)
+	}
+	moov, mdat := -1, -1
+	for i, b := range boxes {
+		if b == "moov" && moov < 0 {
+			moov = i
+		}
+		if b == "mdat" && mdat < 0 {
+			mdat = i
+		}
+	}
+	if moov < 0 {
+		fail("缺少 moov 索引，文件不完整")
+		return v
+	}
+	v.MoovFirst = mdat < 0 || moov < mdat
+
+	probe, err := probeMedia(ctx, path)
+	if err != nil {
+		fail("成片无法解析: %v", err)
+		return v
+	}
+	v.Format = probe.Format.FormatName
+	v.Duration = round3(probe.duration())
+	vs, as := probe.stream("video"), probe.stream("audio")
+	if vs == nil {
+		fail("成片没有视频流")
+	} else {
+		v.VideoCodec, v.Width, v.Height = vs.CodecName, vs.Width, vs.Height
+		v.FPS = parseFrameRate(vs.AvgFrameRate)
+		v.VideoDuration = round3(streamDuration(vs, probe.duration()))
+	}
+	if as == nil {
+		fail("成片没有音频流")
+	} else {
+		v.AudioCodec = as.CodecName
+		v.AudioDuration = round3(streamDuration(as, probe.duration()))
+	}
+	if v.Duration <= 0 {
+		fail("成片时长为 0")
+	}
+
+	tol := cfg.VerifyDurationTolerance
+	if vs != nil && as != nil {
+		vStart, _ := strconv.ParseFloat(vs.StartTime, 64)
+		aStart, _ := strconv.ParseFloat(as.StartTime, 64)
+		v.AVOffset = round3(aStart - vStart)
+		v.AVDurationDiff = round3(v.VideoDuration - v.AudioDuration)
+		if math.Abs(v.AVOffset) > maxAVOffset {
+			fail("音画起点相差 %.3f 秒", v.AVOffset)
+		}
+		if math.Abs(v.AVDurationDiff) > tol {
+			fail("视频流 %.3f 秒与音频流 %.3f 秒相差超过 %.2f 秒", v.VideoDuration, v.AudioDuration, tol)
+		}
+	}
+	if drivingAudio > 0 && vs != nil {
+		v.DurationDiff = round3(v.VideoDuration - drivingAudio)
+		if math.Abs(v.DurationDiff) > tol {
+			fail("成片 %.3f 秒与%s %.3f 秒相差超过 %.2f 秒", v.VideoDuration, expected, drivingAudio, tol)
+		}
+	}
+
+	if cfg.VerifyDecode {
+		n, err := countDecodeErrors(ctx, path)
+		if err != nil {
+			fail("成片解码失败: %v", err)
+		} else {
+			v.DecodeErrors = n
+			if n > 0 {
+				fail("成片解码出现 %d 条错误", n)
+			}
+		}
+	}
+	return v
+}
+
+// verifyResult 在取回阶段校验成片，不通过时任务失败并给出原因
+func verifyResult(ctx context.Context, status *AutoProcessStatus, path string) bool {
+	if !cfg.VerifyOutput {
+		return true
+	}
+	status.CurrentStep = "校验成片"
+	status.beginStage(stageResultVerify)
+	persistTaskStatus(status)
+
+	v := verifyOutput(ctx, path, status.DrivingAudioSeconds, "驱动音频")
+	status.Verification = v
+	if !v.Passed {
+		status.Status = "failed"
+		status.Error = "成片校验失败: " + strings.Join(v.Failures, "; ")
+		taskLog(ctx, status).Error("成片校验失败", "failures", v.Failures)
+		return false
+	}
+	status.noteStage(fmt.Sprintf("%.3fs, a/v %+.3fs, driving %+.3fs", v.VideoDuration, v.AVDurationDiff, v.DurationDiff))
+	return true
+}
+
+// verifyComposed 合成后再次校验最终成片，预期时长为驱动音频加片头片尾；不通过时任务失败
+func verifyComposed(ctx context.Context, status *AutoProcessStatus, plan *composePlan, path string) bool {
+	if !cfg.VerifyOutput {
+		return true
+	}
+	expected := 0.0
+	if status.DrivingAudioSeconds > 0 {
+		expected = status.DrivingAudioSeconds + plan.introSeconds()
+		if plan.Outro != nil {
+			expected += plan.Outro.Duration
+		}
+	}
+	v := verifyOutput(ctx, path, round3(expected), "驱动音频与片头片尾合计")
+	status.ComposedVerification = v
+	if !v.Passed {
+		status.Status = "failed"
+		status.Error = "合成后成片校验失败: " + strings.Join(v.Failures, "; ")
+		taskLog(ctx, status).Error("合成后成片校验失败", "failures", v.Failures)
+		return false
+	}
+	return true
+}
+
+// checkVideoRendition 派生视频的轻量校验：MP4 结构完整、可解析且音视频流齐全、时长与成片一致；
+// 不做完整解码。source 为成片时长，0 时跳过时长比较
+func checkVideoRendition(ctx context.Context, path string, source float64) (*mediaProbe, error) {
+	boxes, err := mp4Boxes(path)
+	if err != nil {
+		return nil, fmt.Errorf("MP4 结构异常: %w", err)
+	}
+	if !slices.Contains(boxes, "moov") {
+		return nil, fmt.Errorf("缺少 moov 索引，文件不完整")
+	}
+	probe, err := probeMedia(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析: %w", err)
+	}
+	if probe.stream("video") == nil || probe.stream("audio") == nil {
+		return nil, fmt.Errorf("缺少音频或视频流")
+	}
+	if d := probe.duration(); source > 0 && math.Abs(d-source) > cfg.VerifyDurationTolerance {
+		return nil, fmt.Errorf("时长 %.3f 秒与成片 %.3f 秒相差超过 %.2f 秒", d, source, cfg.VerifyDurationTolerance)
+	}
+	return probe, nil
+}
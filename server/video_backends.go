@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VideoBackend 一台视频合成服务（GPU 主机）及其容器与挂载目录
+type VideoBackend struct {
+	Name         string `json:"name"`
+	BaseURL      string `json:"base_url"`
+	Container    string `json:"container"`
+	DataRoot     string `json:"data_root"`      // 容器内数据目录，对应 HostVideoDir
+	HostVideoDir string `json:"host_video_dir"` // 宿主机上挂载到容器的目录
+	MaxInflight  int    `json:"max_inflight"`   // 同时渲染的任务上限，0 为不限
+}
+
+// parseVideoBackends 读取 VIDEO_BACKENDS（JSON 数组）；未配置时使用单后端配置
+func parseVideoBackends(c *Config) ([]VideoBackend, error) {
+	def := VideoBackend{
+		Name:         "default",
+		BaseURL:      c.VideoBaseURL,
+		Container:    c.GenVideoContainer,
+		DataRoot:     c.ContainerDataRoot,
+		HostVideoDir: c.HostVideoDir,
+	}
+	raw := getenv("VIDEO_BACKENDS", "")
+	if raw == "" {
+		return []VideoBackend{def}, nil
+	}
+	var list []VideoBackend
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, fmt.Errorf("解析 VIDEO_BACKENDS 失败: %w", err)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("VIDEO_BACKENDS 不能为空数组")
+	}
+	seen := map[string]bool{}
+	for i := range list {
+		b := &list[i]
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("video%d", i+1)
+		}
+		if seen[b.Name] {
+			return nil, fmt.Errorf("VIDEO_BACKENDS 名称重复: %s", b.Name)
+		}
+		seen[b.Name] = true
+		if b.Container == "" {
+			b.Container = def.Container
+		}
+		if b.DataRoot == "" {
+			b.DataRoot = def.DataRoot
+		}
+		if b.HostVideoDir == "" {
+			b.HostVideoDir = def.HostVideoDir
+		}
+	}
+	return list, nil
+}
+
+func defaultVideoBackend() *VideoBackend {
+	return &cfg.VideoBackends[0]
+}
+
+func videoBackendByName(name string) (*VideoBackend, bool) {
+	for i := range cfg.VideoBackends {
+		if cfg.VideoBackends[i].Name == name {
+			return &cfg.VideoBackends[i], true
+		}
+	}
+	return nil, false
+}
+
+// videoBackendForTask 返回任务记录的后端；旧任务或后端已下线时回退到默认后端
+func videoBackendForTask(status *AutoProcessStatus) *VideoBackend {
+	if b, ok := videoBackendByName(status.VideoBackend); ok {
+		return b
+	}
+	return defaultVideoBackend()
+}
+
+// videoBackendParam 手动接口通过 backend 参数指定后端，缺省为默认后端
+func videoBackendParam(name string) (*VideoBackend, error) {
+	if name == "" {
+		return defaultVideoBackend(), nil
+	}
+	if b, ok := videoBackendByName(name); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("未知的视频后端: %s", name)
+}
+
+// 后端健康状态由 startUpstreamProbes 周期探测，未探测过视为健康
+var videoBackendHealth sync.Map // name -> error(nil 表示健康)
+
+func videoBackendHealthy(name string) bool {
+	v, ok := videoBackendHealth.Load(name)
+	return !ok || v == nil
+}
+
+// probeVideoBackends 并发探测各后端 HTTP 可达性
+func probeVideoBackends() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, b := range cfg.VideoBackends {
+		wg.Add(1)
+		go func(b VideoBackend) {
+			defer wg.Done()
+			_, err := checkHTTPReachable(ctx, b.BaseURL)
+			prev, _ := videoBackendHealth.Load(b.Name)
+			if err != nil {
+				videoBackendHealth.Store(b.Name, err)
+				metricVideoBackendUp.WithLabelValues(b.Name).Set(0)
+				if prev == nil {
+					slog.Warn("视频后端不可用", "backend", b.Name, "err", err)
+				}
+				return
+			}
+			videoBackendHealth.Store(b.Name, nil)
+			metricVideoBackendUp.WithLabelValues(b.Name).Set(1)
+			if prev != nil {
+				slog.Info("视频后端已恢复", "backend", b.Name)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+// 每个后端在 Redis 中维护正在使用它的任务集合，集合大小即负载；用集合而非计数器便于清理残留
+func redisVideoLoadKey(name string) string {
+	return fmt.Sprintf("%s:video_load:%s", cfg.QueuePrefix, name)
+}
+
+func videoBackendLoad(ctx context.Context, name string) (int64, error) {
+	if redisClient == nil {
+		return 0, errors.New("Redis 未初始化")
+	}
+	return redisClient.SCard(ctx, redisVideoLoadKey(name)).Result()
+}
+
+// errVideoBackendsBusy 暂无可用后端：属于容量问题而非任务错误，任务保持排队稍后重试
+var errVideoBackendsBusy = errors.New("没有可用的视频合成后端（均不健康、已满载或本 worker 无法拉取结果）")
+
+// 无可用视频后端时消息退回队列前的等待时间
+const videoBackendBusyDelay = 10 * time.Second
+
+// pickVideoBackend 在本 worker 可拉取结果的健康后端中选择负载最低者；
+// preferred 为任务已记录的后端（重试/恢复），可用且未满时优先沿用
+func pickVideoBackend(ctx context.Context, preferred string) (*VideoBackend, error) {
+	var best *VideoBackend
+	var bestLoad int64
+	for i := range cfg.VideoBackends {
+		b := &cfg.VideoBackends[i]
+		if !videoBackendHealthy(b.Name) || circuitOpen(b.BaseURL) || !canFetchFromBackend(b.Name) {
+			continue
+		}
+		load, err := videoBackendLoad(ctx, b.Name)
+		if err != nil {
+			return nil, fmt.Errorf("读取视频后端负载失败: %w", err)
+		}
+		metricVideoBackendLoad.WithLabelValues(b.Name).Set(float64(load))
+		if b.MaxInflight > 0 && load >= int64(b.MaxInflight) {
+			continue
+		}
+		if b.Name == preferred {
+			return b, nil
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = b, load
+		}
+	}
+	if best == nil {
+		return nil, errVideoBackendsBusy
+	}
+	return best, nil
+}
+
+func acquireVideoBackend(taskID, name string) {
+	if redisClient == nil || name == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := redisClient.SAdd(ctx, redisVideoLoadKey(name), taskID).Err(); err != nil {
+		slog.Warn("记录视频后端负载失败", "backend", name, "task_id", taskID, "err", err)
+	}
+}
+
+func releaseVideoBackend(taskID, name string) {
+	if redisClient == nil || name == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := redisClient.SRem(ctx, redisVideoLoadKey(name), taskID).Err(); err != nil {
+		slog.Warn("释放视频后端负载失败", "backend", name, "task_id", taskID, "err", err)
+	}
+}
+
+// cleanupVideoLoad 移除负载集合中已结束或已不存在的任务（worker 崩溃时的残留）
+func cleanupVideoLoad(ctx context.Context) {
+	for _, b := range cfg.VideoBackends {
+		ids, err := redisClient.SMembers(ctx, redisVideoLoadKey(b.Name)).Result()
+		if err != nil {
+			continue
+		}
+		for _, id := range ids {
+			st, err := loadTaskStatus(id)
+			if err != nil {
+				continue
+			}
+			if st == nil || st.VideoBackend != b.Name || (st.Status != "processing" && st.Status != "queued") {
+				redisClient.SRem(ctx, redisVideoLoadKey(b.Name), id)
+			}
+		}
+	}
+}
+
+// GET /api/admin/video-backends: 视频后端列表、健康状态与负载
+func handleAdminVideoBackends(c *gin.Context) {
+	probeVideoBackends()
+	out := make([]gin.H, 0, len(cfg.VideoBackends))
+	for _, b := range cfg.VideoBackends {
+		item := gin.H{
+			"name":           b.Name,
+			"base_url":       b.BaseURL,
+			"container":      b.Container,
+			"host_video_dir": filepath.Clean(b.HostVideoDir),
+			"max_inflight":   b.MaxInflight,
+			"healthy":        videoBackendHealthy(b.Name),
+		}
+		if v, ok := videoBackendHealth.Load(b.Name); ok && v != nil {
+			item["error"] = v.(error).Error()
+		}
+		if load, err := videoBackendLoad(c.Request.Context(), b.Name); err == nil {
+			item["load"] = load
+		}
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"backends": out})
+}
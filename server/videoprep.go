@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VideoPrep 形象视频的预处理选项，提交任务时确定并随任务记录
+type VideoPrep struct {
+	TrimStart float64 `json:"trim_start,omitempty"` // 截取起点（秒）
+	TrimEnd   float64 `json:"trim_end,omitempty"`   // 截取终点（秒），0 表示到结尾
+	Crop      string  `json:"crop,omitempty"`       // 裁剪区域 w:h:x:y（像素）
+	Width     int     `json:"width,omitempty"`      // 目标分辨率，按比例缩放后居中裁剪填满
+	Height    int     `json:"height,omitempty"`
+	FPS       float64 `json:"fps,omitempty"`  // 统一帧率
+	Fill      string  `json:"fill,omitempty"` // 视频短于合成音频时的补齐方式：none|loop|pingpong
+}
+
+const (
+	videoFillNone     = "none"
+	videoFillLoop     = "loop"
+	videoFillPingPong = "pingpong"
+)
+
+var (
+	cropPattern = regexp.MustCompile(`^\d+:\d+:\d+:\d+$`)
+	sizePattern = regexp.MustCompile(`^(\d+)[xX:](\d+)$`)
+)
+
+// videoPrepFromForm 读取 video_trim_start、video_trim_end、video_crop、video_size（如 1080x1920）、video_fps、video_fill
+func videoPrepFromForm(c *gin.Context) (VideoPrep, error) {
+	p := VideoPrep{Fill: cfg.VideoFill}
+	var err error
+	parseSeconds := func(field string) float64 {
+		v := strings.TrimSpace(c.PostForm(field))
+		if v == "" || err != nil {
+			return 0
+		}
+		f, perr := strconv.ParseFloat(v, 64)
+		if perr != nil || f < 0 {
+			err = fmt.Errorf("%s 须为非负秒数: %q", field, v)
+		}
+		return f
+	}
+	p.TrimStart = parseSeconds("video_trim_start")
+	p.TrimEnd = parseSeconds("video_trim_end")
+	p.FPS = parseSeconds("video_fps")
+	if err != nil {
+		return p, err
+	}
+	if p.TrimEnd > 0 && p.TrimEnd <= p.TrimStart {
+		return p, fmt.Errorf("video_trim_end 必须大于 video_trim_start")
+	}
+	if p.FPS > 60 {
+		return p, fmt.Errorf("video_fps 不能超过 60")
+	}
+	if v := strings.TrimSpace(c.PostForm("video_crop")); v != "" {
+		if !cropPattern.MatchString(v) {
+			return p, fmt.Errorf("video_crop 格式为 w:h:x:y: %q", v)
+		}
+		p.Crop = v
+	}
+	if v := strings.TrimSpace(c.PostForm("video_size")); v != "" {
+		m := sizePattern.FindStringSubmatch(v)
+		if m == nil {
+			return p, fmt.Errorf("video_size 格式为 宽x高: %q", v)
+		}
+		p.Width, _ = strconv.Atoi(m[1])
+		p.Height, _ = strconv.Atoi(m[2])
+		// libx264 + yuv420p 要求偶数宽高
+		if p.Width < 64 || p.Height < 64 || p.Width > 4096 || p.Height > 4096 || p.Width%2 != 0 || p.Height%2 != 0 {
+			return p, fmt.Errorf("video_size 宽高须为 64-4096 之间的偶数: %q", v)
+		}
+	}
+	if v := strings.TrimSpace(c.PostForm("video_fill")); v != "" {
+		p.Fill = v
+	}
+	switch p.Fill {
+	case videoFillNone, videoFillLoop, videoFillPingPong:
+	default:
+		return p, fmt.Errorf("video_fill 仅支持 none|loop|pingpong: %q", p.Fill)
+	}
+	return p, nil
+}
+
+// transforms 是否需要对画面做截取、裁剪、缩放或改帧率
+func (p VideoPrep) transforms() bool {
+	return p.TrimStart > 0 || p.TrimEnd > 0 || p.Crop != "" || p.Width > 0 || p.FPS > 0
+}
+
+// signature 用于缓存键
+func (p VideoPrep) signature() string {
+	return fmt.Sprintf("ss=%g,to=%g,crop=%s,size=%dx%d,fps=%g", p.TrimStart, p.TrimEnd, p.Crop, p.Width, p.Height, p.FPS)
+}
+
+// renderCompatible 渲染服务按 H.264 + yuv420p 读取，其他编码需要转码
+func renderCompatible(v *probeStream) bool {
+	return v != nil && v.CodecName == "h264" && (v.PixFmt == "yuv420p" || v.PixFmt == "yuvj420p")
+}
+
+// x264Args 统一的转码参数，保证渲染服务可读且便于浏览器预览
+var x264Args = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p", "-movflags", "+faststart"}
+
+// prepareAvatarVideo 去音轨并按选项截取、裁剪、缩放、改帧率，输出 H.264 静音视频
+func prepareAvatarVideo(ctx context.Context, src, dst string, p VideoPrep) error {
+	args := []string{"-y"}
+	if p.TrimStart > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(p.TrimStart, 'f', -1, 64))
+	}
+	args = append(args, "-i", src)
+	if p.TrimEnd > 0 {
+		args = append(args, "-t", strconv.FormatFloat(p.TrimEnd-p.TrimStart, 'f', -1, 64))
+	}
+	var filters []string
+	if p.Crop != "" {
+		filters = append(filters, "crop="+p.Crop)
+	}
+	if p.Width > 0 {
+		filters = append(filters,
+			fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase", p.Width, p.Height),
+			fmt.Sprintf("crop=%d:%d", p.Width, p.Height))
+	}
+	if p.FPS > 0 {
+		filters = append(filters, "fps="+strconv.FormatFloat(p.FPS, 'f', -1, 64))
+	}
+	args = append(args, "-an")
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, x264Args...)
+	args = append(args, dst)
+	if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("%v | %s", err, stderr)
+	}
+	return nil
+}
+
+// fitVideoToAudio 把视频循环（loop）或正放倒放交替（pingpong）到 seconds 秒，输出到 dst
+func fitVideoToAudio(ctx context.Context, src, dst string, seconds float64, mode string) error {
+	loopSrc := src
+	if mode == videoFillPingPong {
+		// 先拼出 正放+倒放 的片段，首尾画面衔接，再循环该片段；
+		// reverse 需要把整段解码帧缓存在内存中，只取前 INPUT_MAX_PINGPONG_SECONDS 秒
+		loopSrc = strings.TrimSuffix(dst, ".mp4") + ".pingpong.mp4"
+		defer os.Remove(loopSrc)
+		args := []string{"-y"}
+		if limit := cfg.InputLimits.MaxPingPongSeconds; limit > 0 {
+			args = append(args, "-t", strconv.FormatFloat(limit, 'f', 3, 64))
+		}
+		args = append(args, "-i", src,
+			"-filter_complex", "[0:v]split[f][b];[b]reverse[r];[f][r]concat=n=2:v=1:a=0[v]",
+			"-map", "[v]", "-an")
+		args = append(args, x264Args...)
+		args = append(args, loopSrc)
+		_, stderr, err := run(ctx, "ffmpeg", args...)
+		if err != nil {
+			return fmt.Errorf("生成正倒放片段失败: %v | %s", err, stderr)
+		}
+	}
+	args := []string{"-y", "-stream_loop", "-1", "-i", loopSrc, "-t", strconv.FormatFloat(seconds, 'f', 3, 64), "-an"}
+	args = append(args, x264Args...)
+	args = append(args, dst)
+	if _, stderr, err := run(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("%v | %s", err, stderr)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	queueStarted bool
+	// workerWG 在消费循环退出（含当前任务收尾）后完成
+	workerWG sync.WaitGroup
+)
+
+// enqueueTask 为任务分配新的投递 ID 后发布；worker 会丢弃投递 ID 不一致的旧消息，
+// 避免重新排队后 broker 重投的旧消息导致重复执行
+func enqueueTask(status *AutoProcessStatus, t queuedTask) error {
+	t.DispatchID = newRequestID()
+	status.DispatchID = t.DispatchID
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if !hasFetchCapableWorker(ctx) {
+		status.CurrentStep = "等待排队执行（暂无可拉取渲染结果的 worker 在线）"
+	}
+	cancel()
+	persistTaskStatus(status)
+	return publishTask(t)
+}
+
+// startQueueWorker 启动消费循环：stop 取消后停止订阅，taskCtx 取消后中断当前任务并交回队列
+func startQueueWorker(stop, taskCtx context.Context) {
+	if queueStarted {
+		return
+	}
+	queueStarted = true
+	host, _ := os.Hostname()
+	consumerTag := fmt.Sprintf("%s-%d", host, os.Getpid())
+	workerWG.Add(1)
+	go func() {
+		defer workerWG.Done()
+		for stop.Err() == nil {
+			// 无法拉取渲染结果的 worker 不订阅队列，任务只会分给具备能力的 worker
+			if !canFetchResults(detectCapabilities()) {
+				slog.Warn("worker 无法访问渲染结果（既无本地 temp 目录也无 docker），暂不消费任务")
+				select {
+				case <-stop.Done():
+				case <-time.After(cfg.ReaperInterval):
+				}
+				continue
+			}
+			_, ch, _ := currentRabbitChannels()
+			if ch == nil || ch.IsClosed() {
+				slog.Warn("RabbitMQ 通道未就绪，等待重连")
+				waitRabbitReconnect(5 * time.Second)
+				continue
+			}
+			msgs, err := ch.Consume(rabbitQueueName, consumerTag, false, false, false, false, nil)
+			if err != nil {
+				slog.Error("RabbitMQ 消费初始化失败", "err", err)
+				waitRabbitReconnect(5 * time.Second)
+				continue
+			}
+			slog.Info("任务队列工作线程已启动", "queue", rabbitQueueName, "consumer", consumerTag)
+
+			// 收到停机信号后取消订阅，正在处理的消息照常收尾
+			sessionDone := make(chan struct{})
+			go func() {
+				select {
+				case <-stop.Done():
+					if err := ch.Cancel(consumerTag, false); err != nil {
+						slog.Warn("取消 RabbitMQ 订阅失败", "err", err)
+					}
+				case <-sessionDone:
+				}
+			}()
+			for msg := range msgs {
+				handleDelivery(stop, taskCtx, msg)
+			}
+			close(sessionDone)
+			if stop.Err() != nil {
+				break
+			}
+			slog.Warn("RabbitMQ 消费通道已关闭，等待重连")
+			waitRabbitReconnect(5 * time.Second)
+		}
+		slog.Info("任务队列工作线程已停止")
+	}()
+}
+
+func handleDelivery(stop, taskCtx context.Context, msg amqp.Delivery) {
+	var t queuedTask
+	if err := json.Unmarshal(msg.Body, &t); err != nil {
+		slog.Error("解析任务消息失败", "err", err)
+		msg.Nack(false, false)
+		return
+	}
+
+	// 以 Redis 中的最新状态为准，过滤已结束或已被重新投递的任务
+	if current, err := loadTaskStatus(t.TaskID); err == nil && current != nil {
+		if current.DispatchID != "" && t.DispatchID != current.DispatchID {
+			slog.Info("丢弃过期的任务消息", "task_id", t.TaskID, "dispatch_id", t.DispatchID)
+			msg.Ack(false)
+			return
+		}
+		if current.Status == "completed" || current.Status == "failed" {
+			slog.Info("任务已结束，跳过重复投递", "task_id", t.TaskID, "status", current.Status)
+			msg.Ack(false)
+			return
+		}
+		// 发布确认超时等情况下同一任务可能被投递两次：其他仍在心跳的 worker 正在处理时直接丢弃，
+		// 该 worker 失联后由回收流程重新投递
+		if current.Status == "processing" && current.WorkerID != "" && current.WorkerID != workerID {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			alive, err := workerAlive(ctx, current.WorkerID)
+			cancel()
+			if err == nil && alive {
+				slog.Info("任务正由其他 worker 处理，跳过重复投递", "task_id", t.TaskID, "owner", current.WorkerID)
+				msg.Ack(false)
+				return
+			}
+		}
+		// 已提交渲染的任务只能由能访问对应视频后端的 worker 继续
+		if current.Checkpoint == checkpointSubmitted && !canFetchFromBackend(videoBackendForTask(current).Name) {
+			slog.Info("本 worker 无法拉取该任务所在视频后端的结果，退回队列", "task_id", t.TaskID, "backend", current.VideoBackend)
+			time.Sleep(2 * time.Second)
+			msg.Nack(false, true)
+			return
+		}
+		taskStatusMu.Lock()
+		taskStatusMap[t.TaskID] = current
+		taskStatusMu.Unlock()
+	}
+
+	status := getOrCreateTaskStatus(t.TaskID)
+	if status.StartTime == 0 {
+		status.StartTime = time.Now().Unix()
+	}
+	if !holdForUpstreams(stop, status, t.Req) {
+		// 暂缓期间收到停机信号，消息退回队列
+		if err := msg.Nack(false, true); err != nil {
+			slog.Error("RabbitMQ 消息退回失败", "task_id", t.TaskID, "err", err)
+		}
+		return
+	}
+	status.Status = "processing"
+	status.WorkerID = workerID
+	status.CurrentStep = "排队完成，开始处理"
+	if status.Progress < 5 {
+		status.Progress = 5
+	}
+	persistTaskStatus(status)
+	rid := t.RequestID
+	if rid == "" {
+		rid = newRequestID()
+	}
+	ctx := withRequestID(taskCtx, rid)
+	ctx = withLogger(ctx, slog.Default().With("request_id", rid, "worker_id", workerID))
+	setWorkerTask(t.TaskID)
+	processAutomatically(ctx, t.TaskID, t.AudioPath, t.VideoPath, t.Req)
+	setWorkerTask("")
+
+	if status.Status == "queued" {
+		// 停机时交回队列，由下一个 worker 从检查点继续；
+		// 视频后端满载时稍等再退回，避免消息在 worker 间空转
+		if taskCtx.Err() == nil {
+			select {
+			case <-stop.Done():
+			case <-time.After(videoBackendBusyDelay):
+			}
+		}
+		if err := msg.Nack(false, true); err != nil {
+			slog.Error("RabbitMQ 消息退回失败", "task_id", t.TaskID, "err", err)
+		}
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		slog.Error("确认 RabbitMQ 消息失败", "task_id", t.TaskID, "err", err)
+	}
+}
+
+// holdForUpstreams 在任务依赖的上游全部熔断时保持消息未确认并等待，避免任务在熔断期间连续失败；
+// 停机时返回 false
+func holdForUpstreams(stop context.Context, status *AutoProcessStatus, req AutoProcessReq) bool {
+	announced := false
+	for {
+		reason, until := upstreamHold(status, req)
+		if reason == "" {
+			return true
+		}
+		if !announced {
+			status.CurrentStep = fmt.Sprintf("暂缓执行：%s，等待恢复", reason)
+			persistTaskStatus(status)
+			slog.Warn("上游熔断，任务暂缓执行", "task_id", status.TaskID, "reason", reason)
+			announced = true
+		}
+		wait := time.Until(until)
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-stop.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// workerInfo 为 worker 在 Redis 中的注册信息，键带 TTL，停止续期即视为失联
+type workerInfo struct {
+	ID           string              `json:"id"`
+	Host         string              `json:"host"`
+	PID          int                 `json:"pid"`
+	StartedAt    int64               `json:"started_at"`
+	HeartbeatAt  int64               `json:"heartbeat_at"`
+	CurrentTask  string              `json:"current_task,omitempty"`
+	Capabilities []string            `json:"capabilities"`
+	Backends     map[string][]string `json:"backends,omitempty"` // 视频后端 -> 可用能力
+}
+
+var (
+	workerID   string
+	workerMu   sync.Mutex
+	workerSelf workerInfo
+	// workerDone 在注销时关闭；停机收尾期间仍需续期，避免任务被其他实例误回收
+	workerDone = make(chan struct{})
+)
+
+func redisWorkerKey(id string) string {
+	return fmt.Sprintf("%s:worker:%s", cfg.QueuePrefix, id)
+}
+
+func redisWorkerSetKey() string {
+	return fmt.Sprintf("%s:workers", cfg.QueuePrefix)
+}
+
+func redisReaperLockKey() string {
+	return fmt.Sprintf("%s:reaper_lock", cfg.QueuePrefix)
+}
+
+// workerTTL 心跳键的过期时间，允许丢失两次心跳
+func workerTTL() time.Duration {
+	return 3 * cfg.WorkerHeartbeat
+}
+
+func initWorkerIdentity() {
+	host, _ := os.Hostname()
+	workerID = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newRequestID()[:6])
+	workerSelf = workerInfo{
+		ID:        workerID,
+		Host:      host,
+		PID:       os.Getpid(),
+		StartedAt: time.Now().Unix(),
+	}
+}
+
+// setWorkerTask 更新本 worker 当前处理的任务并立即上报
+func setWorkerTask(taskID string) {
+	workerMu.Lock()
+	workerSelf.CurrentTask = taskID
+	workerMu.Unlock()
+	sendHeartbeat()
+}
+
+func sendHeartbeat() {
+	if redisClient == nil {
+		return
+	}
+	workerMu.Lock()
+	workerSelf.HeartbeatAt = time.Now().Unix()
+	data, err := json.Marshal(workerSelf)
+	workerMu.Unlock()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, redisWorkerKey(workerID), data, workerTTL())
+	pipe.SAdd(ctx, redisWorkerSetKey(), workerID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("worker 心跳写入失败", "worker_id", workerID, "err", err)
+	}
+}
+
+// startWorkerHeartbeat 定期续期注册信息，并周期性回收失联 worker 的任务
+func startWorkerHeartbeat() {
+	detectCapabilities()
+	sendHeartbeat()
+	slog.Info("worker 已注册", "worker_id", workerID, "heartbeat", cfg.WorkerHeartbeat.String())
+	go func() {
+		ticker := time.NewTicker(cfg.WorkerHeartbeat)
+		defer ticker.Stop()
+		reap := time.NewTicker(cfg.ReaperInterval)
+		defer reap.Stop()
+		for {
+			select {
+			case <-workerDone:
+				return
+			case <-ticker.C:
+				sendHeartbeat()
+			case <-reap.C:
+				detectCapabilities()
+				reapStaleTasks()
+			}
+		}
+	}()
+}
+
+// unregisterWorker 停机时注销，避免其他实例等待 TTL 过期
+func unregisterWorker() {
+	if workerID == "" {
+		return
+	}
+	close(workerDone)
+	if redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	redisClient.Del(ctx, redisWorkerKey(workerID))
+	redisClient.SRem(ctx, redisWorkerSetKey(), workerID)
+}
+
+// listLiveWorkers 返回仍在心跳的 worker，顺带清理集合中已过期的成员
+func listLiveWorkers(ctx context.Context) ([]workerInfo, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("Redis 未初始化")
+	}
+	ids, err := redisClient.SMembers(ctx, redisWorkerSetKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []workerInfo{}, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisWorkerKey(id)
+	}
+	vals, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]workerInfo, 0, len(ids))
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			redisClient.SRem(ctx, redisWorkerSetKey(), ids[i])
+			continue
+		}
+		var w workerInfo
+		if err := json.Unmarshal([]byte(s), &w); err != nil {
+			continue
+		}
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].StartedAt < workers[j].StartedAt })
+	return workers, nil
+}
+
+// workerAlive 判断 worker 的心跳键是否仍在
+func workerAlive(ctx context.Context, id string) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("Redis 未初始化")
+	}
+	n, err := redisClient.Exists(ctx, redisWorkerKey(id)).Result()
+	return n > 0, err
+}
+
+// 升级前写入的处理中任务不在集合中，每个进程首次回收前全量扫描补齐一次
+var processingIndexOnce sync.Once
+
+func backfillProcessingIndex() {
+	statuses, err := listTaskStatuses()
+	if err != nil {
+		slog.Warn("补齐处理中任务集合失败", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, st := range statuses {
+		if st.Status == "processing" {
+			redisClient.SAdd(ctx, redisProcessingTasksKey(), st.TaskID)
+		}
+	}
+}
+
+// reapStaleTasks 回收所属 worker 已失联的 processing 任务，只扫描处理中任务集合；
+// 未记录 worker 的旧任务按 cfg.StaleTaskAfter 判断。多实例间通过 Redis 锁避免重复回收
+func reapStaleTasks() {
+	if redisClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	locked, err := redisClient.SetNX(ctx, redisReaperLockKey(), workerID, cfg.ReaperInterval).Result()
+	cancel()
+	if err != nil || !locked {
+		return
+	}
+
+	processingIndexOnce.Do(backfillProcessingIndex)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := redisClient.SMembers(ctx, redisProcessingTasksKey()).Result()
+	if err != nil {
+		slog.Error("扫描失联任务失败", "err", err)
+		return
+	}
+	cutoff := time.Now().Add(-cfg.StaleTaskAfter).Unix()
+	for _, id := range ids {
+		// 集合与状态在同一事务中写入，这里不清理成员，避免与刚转为 processing 的写入竞争
+		st, err := loadTaskStatus(id)
+		if err != nil || st == nil || st.Status != "processing" {
+			continue
+		}
+		if st.WorkerID == "" {
+			if st.UpdatedAt <= cutoff {
+				reapTask(st, "检测到中断的任务，重新排队")
+			}
+			continue
+		}
+		if alive, err := workerAlive(ctx, st.WorkerID); err != nil || alive {
+			continue
+		}
+		// 重新读取，避免与 worker 正常收尾竞争
+		current, err := loadTaskStatus(st.TaskID)
+		if err != nil || current == nil || current.Status != "processing" || current.WorkerID != st.WorkerID {
+			continue
+		}
+		reapTask(current, fmt.Sprintf("worker %s 已失联，任务重新排队", st.WorkerID))
+	}
+	cleanupVideoLoad(ctx)
+}
+
+// reapTask 重新投递失联任务；超过 cfg.MaxReaps 次的任务判定为失败，避免反复拖垮 worker
+func reapTask(st *AutoProcessStatus, step string) {
+	st.Reaped++
+	if st.Reaped > cfg.MaxReaps {
+		st.endStage(stageOutcomeInterrupted, "")
+		st.Status = "failed"
+		st.Error = fmt.Sprintf("任务所在 worker 多次失联（已回收 %d 次），放弃执行", cfg.MaxReaps)
+		st.EndTime = time.Now().Unix()
+		st.TotalDuration = st.EndTime - st.StartTime
+		st.WorkerID = ""
+		observeTaskOutcome(st)
+		persistTaskStatus(st)
+		slog.Warn("失联任务超过回收上限，已标记失败", "task_id", st.TaskID, "max_reaps", cfg.MaxReaps)
+		return
+	}
+	st.WorkerID = ""
+	requeueStaleTask(st, step)
+}
+
+// GET /api/admin/workers: 在线 worker 及其当前任务
+func handleAdminWorkers(c *gin.Context) {
+	workers, err := listLiveWorkers(c.Request.Context())
+	if err != nil && err != redis.Nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取 worker 列表失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"workers": workers})
+}
@@ -6,8 +6,9 @@ echo "测试自动化处理API..."
 # 创建测试文件
 echo "创建测试文件..."
 mkdir -p /tmp/test_files
-echo "测试音频内容" > /tmp/test_files/test_audio.wav
-echo "测试视频内容" > /tmp/test_files/test_video.mp4
+# 提交时会用 ffprobe 校验素材，需生成真实的音视频
+ffmpeg -y -loglevel error -f lavfi -i "sine=frequency=440:duration=3" /tmp/test_files/test_audio.wav
+ffmpeg -y -loglevel error -f lavfi -i "testsrc=size=720x1280:rate=25:duration=3" -pix_fmt yuv420p /tmp/test_files/test_video.mp4
 
 # 测试API调用
 echo "调用自动化处理API..."